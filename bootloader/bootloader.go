@@ -4,160 +4,51 @@
 package bootloader
 
 import (
-	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
-	"encoding/hex"
-	"errors"
 	"fmt"
 	"io"
-	"math"
 	"net"
-	"os"
 	"time"
 
-	"git.dolansoft.org/lorenz/metanoia-ebm/srec"
-	"github.com/mdlayher/packet"
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
 )
 
-type message struct {
-	SequenceNumber uint16
-	Type           uint16
-	Payload        []byte
-}
-
-func (m *message) MarshalBinary() ([]byte, error) {
-	var buf bytes.Buffer
-	if len(m.Payload) > math.MaxUint16 {
-		return nil, fmt.Errorf("payload larger than 2^16, invalid")
-	}
-
-	binary.Write(&buf, binary.BigEndian, m.SequenceNumber)
-	binary.Write(&buf, binary.BigEndian, uint16(len(m.Payload)))
-	binary.Write(&buf, binary.BigEndian, m.Type)
-	buf.Write(m.Payload)
-	for buf.Len() < 46 {
-		buf.WriteByte(0)
-	}
-	return buf.Bytes(), nil
-}
-
-func parseMessage(data []byte) (*message, error) {
-	if len(data) < 6 {
-		return nil, fmt.Errorf("too short message")
-	}
-	var msg message
-	msg.SequenceNumber = binary.BigEndian.Uint16(data[0:2])
-	payloadLen := binary.BigEndian.Uint16(data[2:4])
-	msg.Type = binary.BigEndian.Uint16(data[4:6])
-	msg.Payload = data[6 : payloadLen+6]
-	return &msg, nil
-}
-
-const (
-	typeAssociateReq   = 0x01
-	typeAssociateRes   = 0x02
-	typeDownloadBegin  = 0x11
-	typeDownloadRecord = 0x12
-	typeDownloadEnd    = 0x13
-	typeAck            = 0x14
+var (
+	metanoiaDefaultAddr = net.HardwareAddr{0x00, 0x0e, 0xad, 0x33, 0x44, 0x55}
 )
 
-func associateRequest(hwaddr net.HardwareAddr) *message {
-	var buf bytes.Buffer
-
-	binary.Write(&buf, binary.BigEndian, uint32(0x20304))
-	buf.Write([]byte(hwaddr))
-	binary.Write(&buf, binary.BigEndian, uint32(1))
-	binary.Write(&buf, binary.BigEndian, uint32(2))
-	binary.Write(&buf, binary.BigEndian, uint32(3))
-	return &message{
-		Type:    typeAssociateReq,
-		Payload: buf.Bytes(),
+// CheckAddr checks that addr can be assigned to a modem. It needs to be a
+// unicast and locally-administered EUI-48 to not collide with any vendor
+// assigned address.
+func CheckAddr(addr net.HardwareAddr) error {
+	if len(addr) != 6 {
+		return fmt.Errorf("modem address %v is not an EUI-48", addr)
 	}
-}
-
-func downloadBegin() *message {
-	return &message{
-		Type:    typeDownloadBegin,
-		Payload: []byte{0xba, 0, 0, 0, 0x00, 0x01, 0x02, 0x03, 0x0a, 0x0b, 0x0c, 0x0d},
+	if addr[0]&0x01 != 0 {
+		return fmt.Errorf("modem address %v is a multicast address", addr)
 	}
-}
-
-func downloadRecord(payload []byte) *message {
-	return &message{
-		Type:    typeDownloadRecord,
-		Payload: payload,
-	}
-}
-
-func downloadEnd(checksum uint32) *message {
-	payload := [8]byte{0, 0, 0, 0, 0xf4, 0xee, 0x00, 0xdd}
-	binary.BigEndian.PutUint32(payload[:4], checksum)
-	return &message{
-		Type:    typeDownloadEnd,
-		Payload: payload[:],
+	if addr[0]&0x02 == 0 {
+		return fmt.Errorf("modem address %v is not locally administered", addr)
 	}
+	return nil
 }
 
-type conn struct {
-	c     *packet.Conn
-	addr  net.HardwareAddr
-	seqNo uint16
-}
-
-func NewConn(iface *net.Interface) (*conn, error) {
-	c, err := packet.Listen(iface, packet.Datagram, 0x6120, &packet.Config{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create socket: %w", err)
-	}
-	return &conn{c: c, addr: metanoiaDefaultAddr, seqNo: 1}, nil
-}
-
-func (c *conn) SetAddr(newAddr net.HardwareAddr) {
-	c.addr = newAddr
+// DeriveAddr derives a stable modem address from the address of the host
+// interface the modem is attached to and modemID, which distinguishes
+// multiple modems on the same interface and can be empty. The result is
+// unicast and locally administered.
+func DeriveAddr(hostAddr net.HardwareAddr, modemID string) net.HardwareAddr {
+	h := sha256.New()
+	h.Write([]byte("metanoia-ebm modem address\x00"))
+	h.Write(hostAddr)
+	h.Write([]byte(modemID))
+	addr := net.HardwareAddr(h.Sum(nil)[:6])
+	addr[0] = addr[0]&^0x01 | 0x02
+	return addr
 }
 
-func (c *conn) Exchange(req *message) (*message, error) {
-	req.SequenceNumber = c.seqNo
-	c.seqNo++
-	reqRaw, err := req.MarshalBinary()
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal req: %w", err)
-	}
-	buf := make([]byte, 1600)
-	for i := 0; i < 5; i++ {
-		if _, err := c.c.WriteTo(reqRaw, &packet.Addr{
-			HardwareAddr: c.addr,
-		}); err != nil {
-			return nil, fmt.Errorf("failed to send packet: %w", err)
-		}
-		c.c.SetReadDeadline(time.Now().Add(1 * time.Second))
-		n, _, err := c.c.ReadFrom(buf)
-		if errors.Is(err, os.ErrDeadlineExceeded) {
-			fmt.Println("No response in 1s, retrying")
-			continue
-		}
-		if err != nil {
-			return nil, fmt.Errorf("error reading response: %w", err)
-		}
-		res, err := parseMessage(buf[:n])
-		if err != nil {
-			return nil, fmt.Errorf("error parsing response: %w", err)
-		}
-		if res.SequenceNumber != req.SequenceNumber {
-			fmt.Printf("Bad sequence number %d, expected %d, dropping", res.SequenceNumber, req.SequenceNumber)
-			continue
-		}
-		return res, nil
-	}
-	return nil, errors.New("no response after 5 tries")
-}
-
-var (
-	metanoiaDefaultAddr = net.HardwareAddr{0x00, 0x0e, 0xad, 0x33, 0x44, 0x55}
-)
-
 type XorStream struct {
 	W   io.Writer
 	Key []byte
@@ -173,87 +64,218 @@ func (s *XorStream) Write(data []byte) (int, error) {
 	return s.W.Write(processedData)
 }
 
+// Options control a firmware download. The zero value is valid.
+type Options struct {
+	// Progress is called after every record and retry.
+	Progress func(Progress)
+	// KeepRecords sends every S-Record as its own DownloadRecord like the
+	// original implementation. By default, contiguous S-Records are merged
+	// and split into DownloadRecords of up to RecordData bytes to need fewer
+	// round trips.
+	KeepRecords bool
+	// RecordData is the maximum number of data bytes per merged
+	// DownloadRecord. It needs to be a multiple of 4 and defaults to
+	// fwpack.MaxRecordData, the most the single length byte of a record can
+	// describe.
+	RecordData int
+	// Regions are the memory regions records need to be in, see VerifySrec.
+	Regions []Region
+	// Checksum selects the checksum sent in DownloadEnd. It defaults to one
+	// derived from the firmware, see ChecksumFirmware.
+	Checksum Checksum
+	// Session continues a session, like the one returned by Probe, instead
+	// of starting a new one. The modem is only associated again if the
+	// session is not yet associated with the address to assign.
+	Session *Session
+}
+
+// Progress describes a running firmware download.
+type Progress struct {
+	Records      int
+	TotalRecords int
+	Bytes        int
+	TotalBytes   int
+	// Retries is the number of requests which had to be sent again.
+	Retries int
+	Elapsed time.Duration
+	// ETA is the estimated time until all records are sent, extrapolated
+	// from the bytes sent so far. It is zero until the first record is sent.
+	ETA time.Duration
+}
+
+// Summary describes a completed boot.
+type Summary struct {
+	Records int `json:"records"`
+	Bytes   int `json:"bytes"`
+	Retries int `json:"retries"`
+	// Associate is the time to assign the address and begin the download.
+	Associate time.Duration `json:"associate"`
+	// Download is the time to send all records.
+	Download time.Duration `json:"download"`
+	// End is the time for the bootloader to accept DownloadEnd.
+	End   time.Duration `json:"end"`
+	Total time.Duration `json:"total"`
+}
+
 // DownloadAndBoot connects to the modem attached to the pc connection, assigns
 // it hwAddr as a MAC address, downloads the firmware in S-Record format (only
-// S3 records/32 bit addresses supported) and boots it.
-func DownloadAndBoot(pc *packet.Conn, hwAddr net.HardwareAddr, firmwareSrec io.Reader) error {
-	c := conn{
-		c:     pc,
-		addr:  metanoiaDefaultAddr,
-		seqNo: 1,
+// S3 records/32 bit addresses supported) and boots it. The firmware is
+// verified as described in VerifySrec before anything is sent. Contiguous
+// S-Records are merged unless opts.KeepRecords is set. pc is generally a
+// *packet.Conn bound to the EBM ethertype, destinations are passed to it as
+// *packet.Addr.
+//
+// It is not known what the DownloadEnd checksum is calculated over, so it is
+// selected by opts.Checksum, see SPEC.md.
+func DownloadAndBoot(pc net.PacketConn, hwAddr net.HardwareAddr, firmwareSrec io.Reader, opts *Options) (*Summary, error) {
+	recordData := fwpack.MaxRecordData
+	if opts != nil && opts.RecordData != 0 {
+		recordData = opts.RecordData
 	}
-
-	res, err := c.Exchange(associateRequest(hwAddr))
-	if err != nil {
-		return fmt.Errorf("error exchanging EBM message: %w", err)
-	}
-	if res.Type != typeAssociateRes {
-		return fmt.Errorf("invalid response to AssociateRequest: %+v", res)
+	if recordData <= 0 || recordData > fwpack.MaxRecordData || recordData%4 != 0 {
+		return nil, fmt.Errorf("record data size %d is not a multiple of 4 between 4 and %d", recordData, fwpack.MaxRecordData)
 	}
-	if res.Payload[0] != 0 {
-		return fmt.Errorf("error status %d in AssociateRespone", res.Payload[0])
-	}
-	c.SetAddr(hwAddr)
 
-	res2, err := c.Exchange(downloadBegin())
-	if err != nil {
-		return fmt.Errorf("error exchanging EBM message: %w", err)
+	var regions []Region
+	if opts != nil {
+		regions = opts.Regions
 	}
-	if res2.Type != typeAck {
-		return fmt.Errorf("invalid response to DownloadBegin: %+v", res)
-	}
-	if res2.Payload[0] != 0 {
-		return fmt.Errorf("error status %d in DownloadAck", res2.Payload[0])
-	}
-
-	key, err := hex.DecodeString("b4df157369be2ae7d37c55cea6f8ab9d4df1573b9be2ae7637c55ced6f8ab9dadf1573b4be2ae7697c55ced3f8ab9da6f1573b4de2ae769bc55ced378ab9da6f1573b4df2ae769be55ced37cab9da6f8573b4df1ae769be25ced37c5b9da6f8a73b4df15e769be2aced37c559da6f8ab3b4df157769be2aeed37c55cda6f8ab9")
+	segments, err := readSrec(firmwareSrec, regions)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+
 	var buf bytes.Buffer
 	os := XorStream{
 		W:   &buf,
-		Key: key,
+		Key: fwpack.Key,
 	}
-
-	fwS := bufio.NewScanner(firmwareSrec)
-	for fwS.Scan() {
+	var records [][]byte
+	if opts == nil || !opts.KeepRecords {
+		segments = mergeSegments(segments)
+	}
+	// S-Records are never longer than a record, but merged ones are
+	segments = splitSegments(segments, recordData)
+	for _, seg := range segments {
 		buf.Reset()
-		typ, payload, err := srec.ParseGeneric(fwS.Text())
-		if err != nil {
-			return fmt.Errorf("error parsing S-Record %q: %w", fwS.Text(), err)
-		}
-		if typ != 3 {
-			continue
+		binary.Write(&os, binary.BigEndian, seg.addr)
+		binary.Write(&os, binary.BigEndian, uint32(len(seg.data)/4))
+		os.Write(seg.data)
+		records = append(records, append([]byte(nil), buf.Bytes()...))
+	}
+	return download(pc, hwAddr, records, nil, opts)
+}
+
+// segment is data to be put at addr.
+type segment struct {
+	addr uint32
+	data []byte
+	// pos describes where the segment is from in error messages.
+	pos string
+}
+
+// mergeSegments merges each segment with the previous one if it directly
+// follows it. The order of the segments is kept.
+func mergeSegments(segs []segment) []segment {
+	var merged []segment
+	for _, s := range segs {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if uint64(last.addr)+uint64(len(last.data)) == uint64(s.addr) {
+				last.data = append(last.data, s.data...)
+				continue
+			}
 		}
-		addr := payload[0:4]
-		data := payload[4:]
-		os.Write(addr)
-		binary.Write(&os, binary.BigEndian, uint32(len(data)/4))
-		os.Write(data)
+		merged = append(merged, segment{s.addr, append([]byte(nil), s.data...), s.pos})
+	}
+	return merged
+}
 
-		res, err := c.Exchange(downloadRecord(buf.Bytes()))
-		if err != nil {
-			return fmt.Errorf("failed to exchange firmware packet: %w", err)
+// splitSegments splits segments into ones with at most size bytes of data.
+func splitSegments(segs []segment, size int) []segment {
+	var split []segment
+	for _, s := range segs {
+		for off := 0; off < len(s.data); off += size {
+			end := off + size
+			if end > len(s.data) {
+				end = len(s.data)
+			}
+			split = append(split, segment{s.addr + uint32(off), s.data[off:end], s.pos})
 		}
-		if res.Type != typeAck {
-			return fmt.Errorf("invalid response to Download: %+v", res)
+	}
+	return split
+}
+
+// download assigns hwAddr to the modem in bootloader mode, sends records as
+// DownloadRecord payloads and finishes the download with the checksum
+// selected in opts. stored is the checksum stored in the firmware pack, if
+// any.
+func download(pc net.PacketConn, hwAddr net.HardwareAddr, records [][]byte, stored *uint32, opts *Options) (*Summary, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := CheckAddr(hwAddr); err != nil {
+		return nil, err
+	}
+	checksum, err := opts.Checksum.sum(records, stored)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	summary := &Summary{}
+	p := Progress{TotalRecords: len(records)}
+	for _, r := range records {
+		p.TotalBytes += len(r)
+	}
+	report := func() {
+		if opts.Progress == nil {
+			return
 		}
-		if res.Payload[0] != 0 {
-			return fmt.Errorf("error status %d in DownloadAck", res.Payload[0])
+		p.Elapsed = time.Since(start)
+		if p.Bytes > 0 {
+			downloadTime := p.Elapsed - summary.Associate
+			p.ETA = time.Duration(float64(downloadTime) * float64(p.TotalBytes-p.Bytes) / float64(p.Bytes))
 		}
+		opts.Progress(p)
+	}
+	s := opts.Session
+	if s == nil {
+		s = NewSession(pc)
+	}
+	retriesBefore := s.Retries()
+	s.OnRetry = func() {
+		p.Retries = s.Retries() - retriesBefore
+		report()
 	}
 
-	// TODO: Figure out checksum (probably CRC32 IEEE, but over what?)
-	res3, err := c.Exchange(downloadEnd(0x02792767))
-	if err != nil {
-		return fmt.Errorf("error exchanging EBM message: %w", err)
+	if !s.Associated() || !bytes.Equal(s.Addr(), hwAddr) {
+		if err := s.Associate(hwAddr); err != nil {
+			return nil, err
+		}
 	}
-	if res3.Type != typeAck {
-		return fmt.Errorf("invalid response to DownloadEnd: %+v", res)
+	if err := s.Begin(); err != nil {
+		return nil, err
 	}
-	if res3.Payload[0] != 0 {
-		return fmt.Errorf("error status %d in DownloadAck", res2.Payload[0])
+	summary.Associate = time.Since(start)
+	report()
+
+	for _, record := range records {
+		if err := s.SendRecord(record); err != nil {
+			return nil, fmt.Errorf("failed to download record %d: %w", p.Records+1, err)
+		}
+		p.Records++
+		p.Bytes += len(record)
+		report()
 	}
-	return nil
+	summary.Download = time.Since(start) - summary.Associate
+
+	if err := s.End(checksum); err != nil {
+		return nil, fmt.Errorf("checksum %08x: %w", checksum, err)
+	}
+	summary.Total = time.Since(start)
+	summary.End = summary.Total - summary.Associate - summary.Download
+	summary.Records = p.Records
+	summary.Bytes = p.Bytes
+	summary.Retries = s.Retries() - retriesBefore
+	return summary, nil
 }
@@ -0,0 +1,208 @@
+package bootloader
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+	"git.dolansoft.org/lorenz/metanoia-ebm/srec"
+)
+
+func TestCheckAddr(t *testing.T) {
+	cases := []struct {
+		addr string
+		ok   bool
+	}{
+		{"de:21:65:12:34:56", true},
+		{"02:00:00:00:00:01", true},
+		{"00:0e:ad:33:44:55", false},
+		{"03:00:00:00:00:01", false},
+	}
+	for _, c := range cases {
+		addr, _ := net.ParseMAC(c.addr)
+		if err := CheckAddr(addr); (err == nil) != c.ok {
+			t.Errorf("CheckAddr(%v) = %v, expected ok %v", addr, err, c.ok)
+		}
+	}
+}
+
+func TestDeriveAddr(t *testing.T) {
+	host := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	a := DeriveAddr(host, "")
+	if err := CheckAddr(a); err != nil {
+		t.Errorf("derived address is not assignable: %v", err)
+	}
+	if !bytes.Equal(a, DeriveAddr(host, "")) {
+		t.Error("derived address is not stable")
+	}
+	if bytes.Equal(a, DeriveAddr(host, "second")) {
+		t.Error("modem ID does not change derived address")
+	}
+}
+
+func TestMergeSplitSegments(t *testing.T) {
+	var segs []segment
+	// 20 contiguous records of 200 bytes, a gap and an unrelated one
+	for i := 0; i < 20; i++ {
+		segs = append(segs, segment{addr: uint32(0x1000 + i*200), data: bytes.Repeat([]byte{byte(i)}, 200)})
+	}
+	segs = append(segs, segment{addr: 0x3000, data: []byte{1, 2, 3, 4}}, segment{addr: 0x1000 + 20*200, data: []byte{5, 6, 7, 8}})
+
+	merged := mergeSegments(segs)
+	if len(merged) != 3 || len(merged[0].data) != 4000 || merged[1].addr != 0x3000 || merged[2].addr != 0x1000+4000 {
+		t.Fatalf("unexpected merged segments %+v", merged)
+	}
+	if !bytes.Equal(merged[0].data[200:400], segs[1].data) {
+		t.Error("merged data is wrong")
+	}
+
+	split := splitSegments(merged, fwpack.MaxRecordData)
+	expected := []struct {
+		addr uint32
+		len  int
+	}{{0x1000, 1020}, {0x1000 + 1020, 1020}, {0x1000 + 2040, 1020}, {0x1000 + 3060, 940}, {0x3000, 4}, {0x1000 + 4000, 4}}
+	if len(split) != len(expected) {
+		t.Fatalf("expected %d segments, got %d", len(expected), len(split))
+	}
+	for i, e := range expected {
+		if split[i].addr != e.addr || len(split[i].data) != e.len {
+			t.Errorf("segment %d at %x with %d bytes, expected %x with %d bytes", i, split[i].addr, len(split[i].data), e.addr, e.len)
+		}
+	}
+}
+
+func TestVerifySrec(t *testing.T) {
+	var good strings.Builder
+	good.WriteString(srec.S0("test"))
+	good.WriteString(srec.S3(0x1000, make([]byte, 16)))
+	good.WriteString(srec.S3(0x1010, make([]byte, 8)))
+	good.WriteString("S5030002FA\n")
+	good.WriteString(srec.S7(0x1000))
+	if err := VerifySrec(strings.NewReader(good.String()), []Region{{0x1000, 0x1fff}}); err != nil {
+		t.Errorf("valid firmware rejected: %v", err)
+	}
+
+	var bad strings.Builder
+	bad.WriteString(srec.S3(0x1000, make([]byte, 6)))
+	bad.WriteString(srec.S0("late header"))
+	bad.WriteString(srec.S3(0x1004, make([]byte, 8)))
+	bad.WriteString(srec.S3(0x9000, make([]byte, 4)))
+	bad.WriteString(srec.S7(0x1000))
+	bad.WriteString("S5030001FB\n")
+	err := VerifySrec(strings.NewReader(bad.String()), []Region{{0x1000, 0x1fff}})
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	expected := []string{
+		"line 2: S0 header is not the first record",
+		"line 6: S5 record counts 1 records, but 3 precede it",
+		"line 5: S7 entry point is not the last record",
+		"line 1: 6 data bytes at 0x00001000 are not a multiple of 4",
+		"line 4: 4 bytes at 0x00009000 are outside of all memory regions",
+		"line 3: data at 0x00001004 overlaps line 1 (0x00001000-0x00001005)",
+	}
+	if len(verr.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %q", len(expected), verr.Problems)
+	}
+	for i := range expected {
+		if verr.Problems[i] != expected[i] {
+			t.Errorf("problem %d: got %q, expected %q", i, verr.Problems[i], expected[i])
+		}
+	}
+
+	if err := VerifySrec(strings.NewReader(srec.S0("empty")), nil); err == nil {
+		t.Error("empty firmware accepted")
+	}
+
+	var entry strings.Builder
+	entry.WriteString(srec.S3(0x1000, make([]byte, 16)))
+	entry.WriteString(srec.S7(0x1010))
+	err = VerifySrec(strings.NewReader(entry.String()), nil)
+	if !errors.As(err, &verr) || len(verr.Problems) != 1 || verr.Problems[0] != "line 2: S7 entry point 0x00001010 is not inside of any record" {
+		t.Errorf("expected entry point outside of records to be reported, got %v", err)
+	}
+
+	// I/O space is outside of the default regions
+	if err := VerifySrec(strings.NewReader(srec.S3(0xf0000000, make([]byte, 4))), nil); !errors.As(err, &verr) {
+		t.Errorf("expected data outside of the default regions to be rejected, got %v", err)
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	payloads := []Payload{
+		&AssociateRequest{Addr: net.HardwareAddr{0x02, 0x21, 0x65, 0x12, 0x34, 0x56}},
+		&AssociateResponse{Status: 3},
+		&DownloadBegin{},
+		&DownloadRecord{Record: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		&DownloadEnd{Checksum: 0x02792767},
+		&Ack{Status: 1},
+	}
+	for i, p := range payloads {
+		msg, err := NewMessage(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.SequenceNumber = uint16(i)
+		raw, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseMessage(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := parsed.Decode()
+		if err != nil {
+			t.Fatalf("%v: %v", typeName(p.Type()), err)
+		}
+		if !reflect.DeepEqual(decoded, p) {
+			t.Errorf("%v decoded as %+v, expected %+v", typeName(p.Type()), decoded, p)
+		}
+	}
+}
+
+// TestKnownChecksum compares the checksum candidates of the firmware pack
+// in $EBM_KNOWN_PACK, which needs to contain the firmware build KnownChecksum
+// was captured for, to it.
+func TestKnownChecksum(t *testing.T) {
+	path := os.Getenv("EBM_KNOWN_PACK")
+	if path == "" {
+		t.Skip("EBM_KNOWN_PACK is not set")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := fwpack.Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := p.Find(fwpack.SignatureMT5321)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := p.Image(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates, err := img.ChecksumCandidates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, c := range candidates {
+		t.Logf("%s: %08x", c.Name, c.Checksum)
+		found = found || c.Checksum == KnownChecksum
+	}
+	t.Logf("stored: %08x", img.Checksum)
+	if !found && img.Checksum != KnownChecksum {
+		t.Errorf("no candidate reproduces %08x", KnownChecksum)
+	}
+}
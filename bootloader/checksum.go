@@ -0,0 +1,104 @@
+package bootloader
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+)
+
+// KnownChecksum is the DownloadEnd checksum the original implementation
+// sent for every firmware. It only matches a single firmware build, no
+// checksum candidate computed from a firmware has been shown to reproduce it
+// yet, see fwpack.Image.ChecksumCandidates.
+const KnownChecksum = 0x02792767
+
+// ChecksumMode selects where the DownloadEnd checksum comes from.
+type ChecksumMode int
+
+const (
+	// ChecksumFirmware derives the checksum from the firmware being sent:
+	// the stored one for firmware packs, ChecksumRecords for S-Records.
+	ChecksumFirmware ChecksumMode = iota
+	// ChecksumKnown sends KnownChecksum.
+	ChecksumKnown
+	// ChecksumStored sends the checksum stored in front of the image in a
+	// firmware pack. It is not available for S-Records.
+	ChecksumStored
+	// ChecksumRecords sends the CRC-32 (IEEE) over all DownloadRecord
+	// payloads as sent. This is a guess which has not been verified.
+	ChecksumRecords
+	// ChecksumFixed sends Checksum.Value.
+	ChecksumFixed
+)
+
+// Checksum selects the checksum sent in DownloadEnd. The zero value uses
+// ChecksumFirmware.
+type Checksum struct {
+	Mode ChecksumMode
+	// Value is sent if Mode is ChecksumFixed.
+	Value uint32
+}
+
+var checksumModes = map[string]ChecksumMode{
+	"firmware": ChecksumFirmware,
+	"known":    ChecksumKnown,
+	"stored":   ChecksumStored,
+	"records":  ChecksumRecords,
+}
+
+// ParseChecksum parses a checksum selection, which is either "firmware",
+// "known", "stored", "records" or a fixed value like 0x02792767.
+func ParseChecksum(s string) (Checksum, error) {
+	if mode, ok := checksumModes[s]; ok {
+		return Checksum{Mode: mode}, nil
+	}
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("checksum %q is neither firmware, known, stored, records nor a number", s)
+	}
+	return Checksum{Mode: ChecksumFixed, Value: uint32(v)}, nil
+}
+
+func (c Checksum) String() string {
+	for name, mode := range checksumModes {
+		if c.Mode == mode {
+			return name
+		}
+	}
+	return fmt.Sprintf("%#08x", c.Value)
+}
+
+// errNoStoredChecksum is returned for ChecksumStored without a firmware pack.
+var errNoStoredChecksum = errors.New("S-Records contain no stored checksum, only firmware packs do")
+
+// sum returns the checksum to send for records. stored is nil if the
+// firmware has no stored checksum.
+func (c Checksum) sum(records [][]byte, stored *uint32) (uint32, error) {
+	mode := c.Mode
+	if mode == ChecksumFirmware {
+		mode = ChecksumRecords
+		if stored != nil {
+			mode = ChecksumStored
+		}
+	}
+	switch mode {
+	case ChecksumKnown:
+		return KnownChecksum, nil
+	case ChecksumStored:
+		if stored == nil {
+			return 0, errNoStoredChecksum
+		}
+		return *stored, nil
+	case ChecksumRecords:
+		h := crc32.NewIEEE()
+		for _, r := range records {
+			h.Write(r)
+		}
+		return h.Sum32(), nil
+	case ChecksumFixed:
+		return c.Value, nil
+	default:
+		return 0, fmt.Errorf("unknown checksum mode %d", mode)
+	}
+}
@@ -0,0 +1,263 @@
+package bootloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+)
+
+// Message is a bootloader protocol message.
+type Message struct {
+	SequenceNumber uint16
+	Type           uint16
+	Payload        []byte
+}
+
+func (m *Message) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if len(m.Payload) > math.MaxUint16 {
+		return nil, fmt.Errorf("payload larger than 2^16, invalid")
+	}
+
+	binary.Write(&buf, binary.BigEndian, m.SequenceNumber)
+	binary.Write(&buf, binary.BigEndian, uint16(len(m.Payload)))
+	binary.Write(&buf, binary.BigEndian, m.Type)
+	buf.Write(m.Payload)
+	for buf.Len() < 46 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseMessage parses a bootloader message, the payload references data.
+func ParseMessage(data []byte) (*Message, error) {
+	if len(data) < 6 {
+		return nil, fmt.Errorf("too short message")
+	}
+	var msg Message
+	msg.SequenceNumber = binary.BigEndian.Uint16(data[0:2])
+	payloadLen := int(binary.BigEndian.Uint16(data[2:4]))
+	if payloadLen+6 > len(data) {
+		return nil, fmt.Errorf("payload length %d exceeds message length %d", payloadLen, len(data))
+	}
+	msg.Type = binary.BigEndian.Uint16(data[4:6])
+	msg.Payload = data[6 : payloadLen+6]
+	return &msg, nil
+}
+
+const (
+	TypeAssociateRequest  = 0x01
+	TypeAssociateResponse = 0x02
+	TypeDownloadBegin     = 0x11
+	TypeDownloadRecord    = 0x12
+	TypeDownloadEnd       = 0x13
+	TypeAck               = 0x14
+)
+
+var typeDesc = map[uint16]string{
+	TypeAssociateRequest:  "AssociateRequest",
+	TypeAssociateResponse: "AssociateResponse",
+	TypeDownloadBegin:     "DownloadBegin",
+	TypeDownloadRecord:    "DownloadRecord",
+	TypeDownloadEnd:       "DownloadEnd",
+	TypeAck:               "Ack",
+}
+
+func typeName(t uint16) string {
+	if name, ok := typeDesc[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNK_%d", t)
+}
+
+func (m *Message) String() string {
+	return fmt.Sprintf("type=%s seq=%d payload=%x", typeName(m.Type), m.SequenceNumber, m.Payload)
+}
+
+// Payload is the typed payload of a message.
+type Payload interface {
+	Type() uint16
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(data []byte) error
+}
+
+// NewMessage encodes p into a message. The sequence number is assigned when
+// sending it.
+func NewMessage(p Payload) (*Message, error) {
+	payload, err := p.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %v: %w", typeName(p.Type()), err)
+	}
+	return &Message{Type: p.Type(), Payload: payload}, nil
+}
+
+// Decode decodes the payload according to the message type.
+func (m *Message) Decode() (Payload, error) {
+	var p Payload
+	switch m.Type {
+	case TypeAssociateRequest:
+		p = &AssociateRequest{}
+	case TypeAssociateResponse:
+		p = &AssociateResponse{}
+	case TypeDownloadBegin:
+		p = &DownloadBegin{}
+	case TypeDownloadRecord:
+		p = &DownloadRecord{}
+	case TypeDownloadEnd:
+		p = &DownloadEnd{}
+	case TypeAck:
+		p = &Ack{}
+	default:
+		return nil, fmt.Errorf("unknown message type %v", typeName(m.Type))
+	}
+	if err := p.UnmarshalBinary(m.Payload); err != nil {
+		return nil, fmt.Errorf("invalid %v: %w", typeName(m.Type), err)
+	}
+	return p, nil
+}
+
+// associateMagic precedes the address in AssociateRequest.
+const associateMagic = 0x20304
+
+// AssociateRequest assigns Addr to the modem, which needs to be sent to its
+// default address.
+type AssociateRequest struct {
+	Addr net.HardwareAddr
+}
+
+func (*AssociateRequest) Type() uint16 { return TypeAssociateRequest }
+
+func (r *AssociateRequest) MarshalBinary() ([]byte, error) {
+	if len(r.Addr) != 6 {
+		return nil, fmt.Errorf("address %v is not an EUI-48", r.Addr)
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(associateMagic))
+	buf.Write([]byte(r.Addr))
+	binary.Write(&buf, binary.BigEndian, uint32(1))
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	binary.Write(&buf, binary.BigEndian, uint32(3))
+	return buf.Bytes(), nil
+}
+
+func (r *AssociateRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 10 {
+		return fmt.Errorf("%d bytes too short", len(data))
+	}
+	if magic := binary.BigEndian.Uint32(data[0:4]); magic != associateMagic {
+		return fmt.Errorf("bad magic %x", magic)
+	}
+	r.Addr = append(net.HardwareAddr(nil), data[4:10]...)
+	return nil
+}
+
+// AssociateResponse is the response to AssociateRequest.
+type AssociateResponse struct {
+	// Status is zero on success.
+	Status uint8
+}
+
+func (*AssociateResponse) Type() uint16 { return TypeAssociateResponse }
+
+func (r *AssociateResponse) MarshalBinary() ([]byte, error) {
+	return []byte{r.Status}, nil
+}
+
+func (r *AssociateResponse) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty payload")
+	}
+	r.Status = data[0]
+	return nil
+}
+
+// downloadBeginMagic is the payload of DownloadBegin, its meaning is unknown.
+var downloadBeginMagic = []byte{0xba, 0, 0, 0, 0x00, 0x01, 0x02, 0x03, 0x0a, 0x0b, 0x0c, 0x0d}
+
+// DownloadBegin starts a firmware download.
+type DownloadBegin struct{}
+
+func (*DownloadBegin) Type() uint16 { return TypeDownloadBegin }
+
+func (*DownloadBegin) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), downloadBeginMagic...), nil
+}
+
+func (*DownloadBegin) UnmarshalBinary(data []byte) error {
+	if !bytes.Equal(data, downloadBeginMagic) {
+		return fmt.Errorf("unexpected payload %x", data)
+	}
+	return nil
+}
+
+// DownloadRecord carries a single firmware record.
+type DownloadRecord struct {
+	// Record is the obfuscated record as stored in the firmware pack.
+	Record []byte
+}
+
+func (*DownloadRecord) Type() uint16 { return TypeDownloadRecord }
+
+func (r *DownloadRecord) MarshalBinary() ([]byte, error) {
+	if len(r.Record) > fwpack.MaxRecordData+fwpack.RecordHeaderSize {
+		return nil, fmt.Errorf("record with %d bytes too large", len(r.Record))
+	}
+	return r.Record, nil
+}
+
+func (r *DownloadRecord) UnmarshalBinary(data []byte) error {
+	if len(data) < fwpack.RecordHeaderSize {
+		return fmt.Errorf("%d bytes too short", len(data))
+	}
+	r.Record = data
+	return nil
+}
+
+// downloadEndMagic follows the checksum in DownloadEnd.
+var downloadEndMagic = []byte{0xf4, 0xee, 0x00, 0xdd}
+
+// DownloadEnd finishes a firmware download and boots it.
+type DownloadEnd struct {
+	// Checksum is the CRC-32 of the firmware, see DownloadAndBoot.
+	Checksum uint32
+}
+
+func (*DownloadEnd) Type() uint16 { return TypeDownloadEnd }
+
+func (e *DownloadEnd) MarshalBinary() ([]byte, error) {
+	payload := make([]byte, 4, 8)
+	binary.BigEndian.PutUint32(payload, e.Checksum)
+	return append(payload, downloadEndMagic...), nil
+}
+
+func (e *DownloadEnd) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 || !bytes.Equal(data[4:], downloadEndMagic) {
+		return fmt.Errorf("unexpected payload %x", data)
+	}
+	e.Checksum = binary.BigEndian.Uint32(data[0:4])
+	return nil
+}
+
+// Ack is the response to all download messages.
+type Ack struct {
+	// Status is zero on success.
+	Status uint8
+}
+
+func (*Ack) Type() uint16 { return TypeAck }
+
+func (a *Ack) MarshalBinary() ([]byte, error) {
+	return []byte{a.Status}, nil
+}
+
+func (a *Ack) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty payload")
+	}
+	a.Status = data[0]
+	return nil
+}
@@ -0,0 +1,58 @@
+package bootloader
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+)
+
+// readPack reads the MT-G5321 image from a firmware pack and returns its
+// deobfuscated segments, the obfuscated records and the stored checksum.
+func readPack(r io.ReaderAt) ([]segment, [][]byte, uint32, error) {
+	p, err := fwpack.Open(r)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	e, err := p.Find(fwpack.SignatureMT5321)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	img, err := p.Image(e)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	records, err := img.Records(true)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	segs := make([]segment, len(records))
+	raw := make([][]byte, len(records))
+	for i, r := range records {
+		segs[i] = segment{addr: r.Addr, data: r.Data, pos: fmt.Sprintf("record %d", i+1)}
+		raw[i] = r.Raw
+	}
+	return segs, raw, img.Checksum, nil
+}
+
+// DownloadPackAndBoot is like DownloadAndBoot, but takes a Metanoia firmware
+// pack (firmware_package.b) and downloads the MT-G5321 image from it. The
+// records are sent still obfuscated as stored in the pack, so they are not
+// merged and opts.KeepRecords is ignored. The checksum stored in the image is
+// sent in DownloadEnd unless opts.Checksum selects another one. The image is
+// verified like with VerifyPack before anything is sent.
+func DownloadPackAndBoot(pc net.PacketConn, hwAddr net.HardwareAddr, pack io.ReaderAt, opts *Options) (*Summary, error) {
+	segs, records, checksum, err := readPack(pack)
+	if err != nil {
+		return nil, err
+	}
+	var regions []Region
+	if opts != nil {
+		regions = opts.Regions
+	}
+	if err := verify(segs, nil, regions); err != nil {
+		return nil, err
+	}
+	return download(pc, hwAddr, records, &checksum, opts)
+}
@@ -0,0 +1,123 @@
+package bootloader
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/ebm"
+	"github.com/mdlayher/packet"
+)
+
+// Mode is the state a modem was found in by Probe.
+type Mode int
+
+const (
+	// ModeAbsent means that no modem responded.
+	ModeAbsent Mode = iota
+	// ModeBootloader means that the modem waits for a firmware download.
+	ModeBootloader
+	// ModeOperational means that the modem runs a firmware and speaks the
+	// EBM protocol.
+	ModeOperational
+)
+
+var modeDesc = map[Mode]string{
+	ModeAbsent:      "absent",
+	ModeBootloader:  "bootloader",
+	ModeOperational: "operational",
+}
+
+func (m Mode) String() string {
+	return modeDesc[m]
+}
+
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// ProbeResult describes the modem found by Probe.
+type ProbeResult struct {
+	Mode Mode
+	// Addr is the MAC address an operational modem responded from or the
+	// one a modem in bootloader mode has been assigned.
+	Addr net.HardwareAddr
+	// Session is the associated session with a modem in bootloader mode.
+	// Pass it in Options.Session to download the firmware without
+	// associating the modem again.
+	Session *Session
+}
+
+// DefaultProbeTimeout is the time Probe waits for each response.
+const DefaultProbeTimeout = time.Second
+
+var broadcastAddr = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// Probe finds out whether the modem attached to pc, which needs to be bound
+// to the EBM ethertype, runs a firmware or waits in the bootloader.
+//
+// Operational modems are found by broadcasting an EBM SEARCH_DEVICE request.
+// The bootloader does not answer it and no request is known to be answered
+// without changing its state. It is thus probed with the AssociateRequest
+// starting a download, assigning it addr or its default address if addr is
+// nil. The download should continue with the returned Session. Whether the
+// bootloader accepts a new session associating it again, like when another
+// process downloads the firmware after probing, is not verified.
+func Probe(pc net.PacketConn, timeout time.Duration, addr net.HardwareAddr) (*ProbeResult, error) {
+	search, err := (&ebm.Message{Type: ebm.TypeSearchDevice, Status: ebm.StatusDefault}).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	found, err := probeExchange(pc, search, broadcastAddr, timeout, func(data []byte) bool {
+		res, err := ebm.ParseMessage(data)
+		return err == nil && res.Type == ebm.TypeSearchDeviceResp
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found != nil {
+		return &ProbeResult{Mode: ModeOperational, Addr: found}, nil
+	}
+
+	if addr == nil {
+		addr = metanoiaDefaultAddr
+	}
+	s := NewSession(pc)
+	defaultTimeout, defaultTries := s.Timeout, s.Tries
+	s.Timeout = timeout
+	s.Tries = 1
+	err = s.Associate(addr)
+	if errors.Is(err, ErrNoResponse) {
+		return &ProbeResult{Mode: ModeAbsent}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Timeout, s.Tries = defaultTimeout, defaultTries
+	return &ProbeResult{Mode: ModeBootloader, Addr: addr, Session: s}, nil
+}
+
+// probeExchange sends req to dst and waits until a frame accepted by match
+// arrives. It returns the sender of that frame or nil on timeout.
+func probeExchange(pc net.PacketConn, req []byte, dst net.HardwareAddr, timeout time.Duration, match func([]byte) bool) (net.HardwareAddr, error) {
+	if _, err := pc.WriteTo(req, &packet.Addr{HardwareAddr: dst}); err != nil {
+		return nil, fmt.Errorf("failed to send probe: %w", err)
+	}
+	defer pc.SetReadDeadline(time.Time{})
+	pc.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1514)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read probe response: %w", err)
+		}
+		if match(buf[:n]) {
+			return from.(*packet.Addr).HardwareAddr, nil
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package bootloader
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/mdlayher/packet"
+)
+
+// StatusError is returned if the bootloader responds with a non-zero status.
+type StatusError struct {
+	// Request is the type of the rejected request.
+	Request uint16
+	Status  uint8
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("error status %d in response to %v", e.Status, typeName(e.Request))
+}
+
+// Session is a connection to a modem in bootloader mode. A download consists
+// of Associate, Begin, SendRecord for every record and End, DownloadAndBoot
+// runs all of them.
+type Session struct {
+	pc         net.PacketConn
+	addr       net.HardwareAddr
+	associated bool
+	seqNo      uint16
+	// retries counts requests sent again because of a missing or
+	// mismatched response.
+	retries int
+
+	// Timeout is the time to wait for a response before sending a request
+	// again.
+	Timeout time.Duration
+	// Tries is the number of times a request is sent before giving up.
+	Tries int
+	// OnRetry is called whenever a request is sent again.
+	OnRetry func()
+	// OnExchange is called with every request and its response.
+	OnExchange func(req, res *Message)
+}
+
+// NewSession creates a session with the modem attached to pc, which needs to
+// be bound to the EBM ethertype and use *packet.Addr addresses. It talks to
+// the default address of the bootloader until Associate assigns a new one.
+func NewSession(pc net.PacketConn) *Session {
+	return &Session{
+		pc:      pc,
+		addr:    metanoiaDefaultAddr,
+		seqNo:   1,
+		Timeout: time.Second,
+		Tries:   5,
+	}
+}
+
+// Addr returns the address the modem is talked to at.
+func (s *Session) Addr() net.HardwareAddr {
+	return s.addr
+}
+
+// ErrNoResponse is returned by Exchange if the modem did not respond to any
+// try.
+var ErrNoResponse = errors.New("no response")
+
+// Retries returns the number of requests which had to be sent again.
+func (s *Session) Retries() int {
+	return s.retries
+}
+
+// Exchange sends req and returns the decoded response with the same
+// sequence number, retrying if none arrives.
+func (s *Session) Exchange(req Payload) (Payload, error) {
+	reqMsg, err := NewMessage(req)
+	if err != nil {
+		return nil, err
+	}
+	reqMsg.SequenceNumber = s.seqNo
+	s.seqNo++
+	reqRaw, err := reqMsg.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal req: %w", err)
+	}
+	buf := make([]byte, 1600)
+	for i := 0; i < s.Tries; i++ {
+		if i > 0 {
+			s.retries++
+			if s.OnRetry != nil {
+				s.OnRetry()
+			}
+		}
+		if _, err := s.pc.WriteTo(reqRaw, &packet.Addr{
+			HardwareAddr: s.addr,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to send packet: %w", err)
+		}
+		s.pc.SetReadDeadline(time.Now().Add(s.Timeout))
+		n, _, err := s.pc.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading response: %w", err)
+		}
+		resMsg, err := ParseMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing response: %w", err)
+		}
+		if resMsg.SequenceNumber != reqMsg.SequenceNumber {
+			continue
+		}
+		if s.OnExchange != nil {
+			s.OnExchange(reqMsg, resMsg)
+		}
+		res, err := resMsg.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("invalid response to %v: %w", typeName(reqMsg.Type), err)
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("%w to %v after %d tries", ErrNoResponse, typeName(reqMsg.Type), s.Tries)
+}
+
+// exchangeAck sends req and checks that it is acknowledged.
+func (s *Session) exchangeAck(req Payload) error {
+	res, err := s.Exchange(req)
+	if err != nil {
+		return err
+	}
+	ack, ok := res.(*Ack)
+	if !ok {
+		return fmt.Errorf("invalid response to %v: %v", typeName(req.Type()), typeName(res.Type()))
+	}
+	if ack.Status != 0 {
+		return &StatusError{Request: req.Type(), Status: ack.Status}
+	}
+	return nil
+}
+
+// Associate assigns addr to the modem and talks to it at addr afterwards.
+func (s *Session) Associate(addr net.HardwareAddr) error {
+	req := &AssociateRequest{Addr: addr}
+	res, err := s.Exchange(req)
+	if err != nil {
+		return err
+	}
+	assoc, ok := res.(*AssociateResponse)
+	if !ok {
+		return fmt.Errorf("invalid response to %v: %v", typeName(req.Type()), typeName(res.Type()))
+	}
+	if assoc.Status != 0 {
+		return &StatusError{Request: req.Type(), Status: assoc.Status}
+	}
+	s.addr = addr
+	s.associated = true
+	return nil
+}
+
+// Associated returns true if Associate succeeded, the modem is then talked
+// to at Addr.
+func (s *Session) Associated() bool {
+	return s.associated
+}
+
+// Begin starts the download.
+func (s *Session) Begin() error {
+	return s.exchangeAck(&DownloadBegin{})
+}
+
+// SendRecord downloads a single obfuscated record.
+func (s *Session) SendRecord(record []byte) error {
+	return s.exchangeAck(&DownloadRecord{Record: record})
+}
+
+// End finishes the download, on success the modem boots the firmware.
+func (s *Session) End(checksum uint32) error {
+	return s.exchangeAck(&DownloadEnd{Checksum: checksum})
+}
@@ -0,0 +1,408 @@
+package bootloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+	"git.dolansoft.org/lorenz/metanoia-ebm/srec"
+	"github.com/mdlayher/packet"
+)
+
+type frame struct {
+	data     []byte
+	src, dst net.HardwareAddr
+}
+
+// pipeConn is one end of an in-memory Ethernet link implementing
+// net.PacketConn with *packet.Addr addresses.
+type pipeConn struct {
+	local net.HardwareAddr
+	rx    chan frame
+	peer  *pipeConn
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func newPipe(a, b net.HardwareAddr) (*pipeConn, *pipeConn) {
+	ca := &pipeConn{local: a, rx: make(chan frame, 16)}
+	cb := &pipeConn{local: b, rx: make(chan frame, 16)}
+	ca.peer, cb.peer = cb, ca
+	return ca, cb
+}
+
+func (c *pipeConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case f := <-c.rx:
+		return copy(p, f.data), &packet.Addr{HardwareAddr: f.src}, nil
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *pipeConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.peer.rx <- frame{data: append([]byte(nil), p...), src: c.local, dst: addr.(*packet.Addr).HardwareAddr}
+	return len(p), nil
+}
+
+func (c *pipeConn) Close() error        { return nil }
+func (c *pipeConn) LocalAddr() net.Addr { return &packet.Addr{HardwareAddr: c.local} }
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// simBootloader simulates the bootloader side of a firmware download. As it
+// is not known what the real bootloader calculates the DownloadEnd checksum
+// over, it accepts any checksum unless expectChecksum is set. Tests can only
+// check which checksum is sent, not whether a real bootloader accepts it.
+type simBootloader struct {
+	conn           *pipeConn
+	addr           net.HardwareAddr
+	expectChecksum *uint32
+
+	payloads [][]byte
+	memory   map[uint32]byte
+	booted   bool
+	// checksum is the one received in DownloadEnd
+	checksum uint32
+	// associates counts the AssociateRequests received
+	associates int
+}
+
+func (s *simBootloader) run(t *testing.T) {
+	keyPos := 0
+	deobfuscate := func(b []byte) []byte {
+		out := make([]byte, len(b))
+		fwpack.Deobfuscate(out, b, keyPos)
+		keyPos += len(b)
+		return out
+	}
+	for f := range s.conn.rx {
+		if !bytes.Equal(f.dst, s.addr) {
+			continue
+		}
+		req, err := ParseMessage(f.data)
+		if err != nil {
+			t.Errorf("simulator: %v", err)
+			continue
+		}
+		p, err := req.Decode()
+		if err != nil {
+			t.Errorf("simulator: %v", err)
+			continue
+		}
+		var resPayload Payload = &Ack{}
+		switch p := p.(type) {
+		case *AssociateRequest:
+			resPayload = &AssociateResponse{}
+			s.addr = p.Addr
+			s.associates++
+		case *DownloadBegin:
+		case *DownloadRecord:
+			s.payloads = append(s.payloads, append([]byte(nil), p.Record...))
+			rec := deobfuscate(p.Record)
+			addr := binary.BigEndian.Uint32(rec[0:4])
+			// Like in the firmware pack, only the last byte is the length
+			if rec[4] != 0 || rec[5] != 0 || rec[6] != 0 {
+				t.Errorf("simulator: record at %x has reserved bytes %x", addr, rec[4:7])
+			}
+			words := rec[7]
+			if int(words)*4 != len(rec)-8 {
+				t.Errorf("simulator: record at %x has %d words but %d bytes", addr, words, len(rec)-8)
+			}
+			for i, b := range rec[8:] {
+				s.memory[addr+uint32(i)] = b
+			}
+		case *DownloadEnd:
+			s.checksum = p.Checksum
+			if s.expectChecksum != nil && p.Checksum != *s.expectChecksum {
+				resPayload = &Ack{Status: 1}
+			} else {
+				s.booted = true
+			}
+		}
+		res, _ := NewMessage(resPayload)
+		res.SequenceNumber = req.SequenceNumber
+		raw, _ := res.MarshalBinary()
+		s.conn.WriteTo(raw, &packet.Addr{HardwareAddr: f.src})
+	}
+}
+
+// read returns n bytes of simulated memory at addr.
+func (s *simBootloader) read(addr uint32, n int) []byte {
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = s.memory[addr+uint32(i)]
+	}
+	return out
+}
+
+func testFirmware() (string, map[uint32][]byte) {
+	records := map[uint32][]byte{
+		0x00000000: {0x01, 0x02, 0x03, 0x04},
+		0x00000004: bytes.Repeat([]byte{0xaa}, 64),
+		0x60000000: bytes.Repeat([]byte{0x55, 0x66}, 100),
+	}
+	var sb strings.Builder
+	sb.WriteString(srec.S0("test"))
+	for _, addr := range []uint32{0, 4, 0x60000000} {
+		sb.WriteString(srec.S3(addr, records[addr]))
+	}
+	return sb.String(), records
+}
+
+func TestDownloadAndBoot(t *testing.T) {
+	for _, c := range []struct {
+		keep    bool
+		records int
+	}{
+		// The first two records are contiguous and merged
+		{false, 2},
+		{true, 3},
+	} {
+		host, modem := newPipe(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, metanoiaDefaultAddr)
+		sim := &simBootloader{conn: modem, addr: metanoiaDefaultAddr, memory: make(map[uint32]byte)}
+		go sim.run(t)
+
+		fw, records := testFirmware()
+		assigned := net.HardwareAddr{0x02, 0x21, 0x65, 0x12, 0x34, 0x56}
+		var last Progress
+		summary, err := DownloadAndBoot(host, assigned, strings.NewReader(fw), &Options{
+			Progress:    func(p Progress) { last = p },
+			KeepRecords: c.keep,
+		})
+		close(modem.rx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last.Records != c.records || last.TotalRecords != c.records || last.Bytes != last.TotalBytes || last.ETA != 0 {
+			t.Errorf("keep %v: unexpected final progress %+v", c.keep, last)
+		}
+		if summary.Records != c.records || summary.Bytes != last.TotalBytes || summary.Retries != 0 {
+			t.Errorf("keep %v: unexpected summary %+v", c.keep, summary)
+		}
+		if !sim.booted || sim.checksum != payloadsCRC(sim.payloads) {
+			t.Errorf("keep %v: simulator did not boot with the CRC over the records, got %08x", c.keep, sim.checksum)
+		}
+		if !bytes.Equal(sim.addr, assigned) {
+			t.Errorf("keep %v: simulator has address %v, expected %v", c.keep, sim.addr, assigned)
+		}
+		for addr, data := range records {
+			if got := sim.read(addr, len(data)); !bytes.Equal(got, data) {
+				t.Errorf("keep %v: memory at %x is %x, expected %x", c.keep, addr, got, data)
+			}
+		}
+	}
+}
+
+func TestDownloadAndBootRecordSize(t *testing.T) {
+	host, modem := newPipe(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, metanoiaDefaultAddr)
+	sim := &simBootloader{conn: modem, addr: metanoiaDefaultAddr, memory: make(map[uint32]byte)}
+	go sim.run(t)
+
+	// 10 contiguous S-Records with 2480 bytes in total
+	var sb strings.Builder
+	for i := 0; i < 10; i++ {
+		sb.WriteString(srec.S3(uint32(0x1000+i*248), bytes.Repeat([]byte{byte(i)}, 248)))
+	}
+	_, err := DownloadAndBoot(host, net.HardwareAddr{0x02, 0x21, 0x65, 0x12, 0x34, 0x56}, strings.NewReader(sb.String()), nil)
+	close(modem.rx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int
+	for _, p := range sim.payloads {
+		sizes = append(sizes, len(p)-fwpack.RecordHeaderSize)
+		if len(p)-fwpack.RecordHeaderSize > 255*4 {
+			t.Errorf("record with %d words sent", (len(p)-fwpack.RecordHeaderSize)/4)
+		}
+	}
+	if !reflect.DeepEqual(sizes, []int{1020, 1020, 440}) {
+		t.Errorf("unexpected record sizes %v", sizes)
+	}
+}
+
+func TestDownloadAndBootChecksumRejected(t *testing.T) {
+	host, modem := newPipe(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, metanoiaDefaultAddr)
+	expected := uint32(0x12345678)
+	sim := &simBootloader{conn: modem, addr: metanoiaDefaultAddr, memory: make(map[uint32]byte), expectChecksum: &expected}
+	go sim.run(t)
+	defer close(modem.rx)
+
+	fw, _ := testFirmware()
+	_, err := DownloadAndBoot(host, net.HardwareAddr{0x02, 0x21, 0x65, 0x12, 0x34, 0x56}, strings.NewReader(fw), nil)
+	if err == nil || !strings.Contains(err.Error(), "DownloadEnd") {
+		t.Errorf("expected DownloadEnd to be rejected, got %v", err)
+	}
+}
+
+// payloadsCRC returns the CRC-32 over payloads, which is what ChecksumRecords
+// is defined as. Whether the bootloader accepts it is not known.
+func payloadsCRC(payloads [][]byte) uint32 {
+	h := crc32.NewIEEE()
+	for _, p := range payloads {
+		h.Write(p)
+	}
+	return h.Sum32()
+}
+
+func TestDownloadAndBootChecksumModes(t *testing.T) {
+	cases := []struct {
+		checksum Checksum
+		// expected returns the checksum which needs to be sent
+		expected func(payloads [][]byte) uint32
+		err      bool
+	}{
+		{Checksum{}, payloadsCRC, false},
+		{Checksum{Mode: ChecksumRecords}, payloadsCRC, false},
+		{Checksum{Mode: ChecksumKnown}, func([][]byte) uint32 { return 0x02792767 }, false},
+		{Checksum{Mode: ChecksumFixed, Value: 0xdeadbeef}, func([][]byte) uint32 { return 0xdeadbeef }, false},
+		{Checksum{Mode: ChecksumStored}, nil, true},
+	}
+	for _, c := range cases {
+		host, modem := newPipe(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, metanoiaDefaultAddr)
+		sim := &simBootloader{conn: modem, addr: metanoiaDefaultAddr, memory: make(map[uint32]byte)}
+		go sim.run(t)
+
+		fw, _ := testFirmware()
+		_, err := DownloadAndBoot(host, net.HardwareAddr{0x02, 0x21, 0x65, 0x12, 0x34, 0x56}, strings.NewReader(fw), &Options{Checksum: c.checksum})
+		close(modem.rx)
+		if (err != nil) != c.err {
+			t.Errorf("checksum %v: unexpected error %v", c.checksum, err)
+		}
+		if c.err {
+			if sim.associates != 0 {
+				t.Errorf("checksum %v: modem associated despite the error", c.checksum)
+			}
+			continue
+		}
+		if expected := c.expected(sim.payloads); sim.checksum != expected {
+			t.Errorf("checksum %v: sent %08x, expected %08x", c.checksum, sim.checksum, expected)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	host, modem := newPipe(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, metanoiaDefaultAddr)
+	sim := &simBootloader{conn: modem, addr: metanoiaDefaultAddr, memory: make(map[uint32]byte)}
+	go sim.run(t)
+	res, err := Probe(host, 100*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Mode != ModeBootloader || !bytes.Equal(res.Addr, metanoiaDefaultAddr) {
+		t.Errorf("expected bootloader at %v, got %v at %v", metanoiaDefaultAddr, res.Mode, res.Addr)
+	}
+	close(modem.rx)
+
+	absent, _ := newPipe(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, metanoiaDefaultAddr)
+	res, err = Probe(absent, 10*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Mode != ModeAbsent {
+		t.Errorf("expected no modem, got %v", res.Mode)
+	}
+}
+
+// testPack builds a firmware pack containing fw as MT-G5321 image.
+func testPack(t *testing.T, fw string) []byte {
+	var records []fwpack.Record
+	for _, line := range strings.Split(strings.TrimSpace(fw), "\n") {
+		typ, payload, err := srec.ParseGeneric(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ == 3 {
+			records = append(records, fwpack.Record{Addr: binary.BigEndian.Uint32(payload[0:4]), Data: payload[4:]})
+		}
+	}
+	pack, err := fwpack.Build([]fwpack.BuildImage{
+		// Unrelated image in front of the MT-G5321 one
+		{Signature: 0x12345678},
+		{Signature: fwpack.SignatureMT5321, Records: records},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pack
+}
+
+func TestDownloadPackAndBoot(t *testing.T) {
+	fw, records := testFirmware()
+	pack := testPack(t, fw)
+	// The checksum stored in front of the second image
+	offset := binary.BigEndian.Uint32(pack[2*fwpack.EntrySize+16:])
+	stored := binary.BigEndian.Uint32(pack[offset+4:])
+	for _, opts := range []*Options{nil, {Checksum: Checksum{Mode: ChecksumStored}}} {
+		host, modem := newPipe(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, metanoiaDefaultAddr)
+		sim := &simBootloader{conn: modem, addr: metanoiaDefaultAddr, memory: make(map[uint32]byte)}
+		go sim.run(t)
+
+		_, err := DownloadPackAndBoot(host, net.HardwareAddr{0x02, 0x21, 0x65, 0x12, 0x34, 0x56}, bytes.NewReader(pack), opts)
+		close(modem.rx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sim.booted || sim.checksum != stored {
+			t.Errorf("options %+v: sent checksum %08x, expected the stored %08x", opts, sim.checksum, stored)
+		}
+		for addr, data := range records {
+			if got := sim.read(addr, len(data)); !bytes.Equal(got, data) {
+				t.Errorf("memory at %x is %x, expected %x", addr, got, data)
+			}
+		}
+	}
+}
+
+func TestProbeAndDownload(t *testing.T) {
+	host, modem := newPipe(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, metanoiaDefaultAddr)
+	sim := &simBootloader{conn: modem, addr: metanoiaDefaultAddr, memory: make(map[uint32]byte)}
+	go sim.run(t)
+	defer close(modem.rx)
+
+	assigned := net.HardwareAddr{0x02, 0x21, 0x65, 0x12, 0x34, 0x56}
+	res, err := Probe(host, 100*time.Millisecond, assigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Mode != ModeBootloader || res.Session == nil || !bytes.Equal(res.Addr, assigned) {
+		t.Fatalf("expected associated bootloader at %v, got %+v", assigned, res)
+	}
+	fw, _ := testFirmware()
+	if _, err := DownloadAndBoot(host, assigned, strings.NewReader(fw), &Options{Session: res.Session}); err != nil {
+		t.Fatal(err)
+	}
+	// The download continues the session of the probe
+	if sim.associates != 1 {
+		t.Errorf("expected a single AssociateRequest, got %d", sim.associates)
+	}
+	if !sim.booted {
+		t.Error("simulator did not boot")
+	}
+}
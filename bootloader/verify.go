@@ -0,0 +1,231 @@
+package bootloader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/srec"
+)
+
+// Region is a range of memory addresses, both ends inclusive.
+type Region struct {
+	Start uint32
+	End   uint32
+}
+
+func (r Region) String() string {
+	return fmt.Sprintf("%#08x-%#08x", r.Start, r.End)
+}
+
+func (r Region) contains(s segment) bool {
+	return s.addr >= r.Start && uint64(s.addr)+uint64(len(s.data)) <= uint64(r.End)+1
+}
+
+// ParseRegion parses a region in the form start-end, for example
+// 0x40000000-0x4003ffff.
+func ParseRegion(s string) (Region, error) {
+	startStr, endStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return Region{}, fmt.Errorf("region %q is not in the form start-end", s)
+	}
+	start, err := strconv.ParseUint(strings.TrimSpace(startStr), 0, 32)
+	if err != nil {
+		return Region{}, fmt.Errorf("invalid region start: %w", err)
+	}
+	end, err := strconv.ParseUint(strings.TrimSpace(endStr), 0, 32)
+	if err != nil {
+		return Region{}, fmt.Errorf("invalid region end: %w", err)
+	}
+	if end < start {
+		return Region{}, fmt.Errorf("region %q ends before it starts", s)
+	}
+	return Region{uint32(start), uint32(end)}, nil
+}
+
+// ValidationError lists all problems found in a firmware image.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid firmware: %s", strings.Join(e.Problems, "; "))
+}
+
+// DefaultRegions are the memory regions records need to be in if no others
+// are given. They are provisional: the memory map of the MT-G5321 is not
+// known, so they only exclude the top 256 MiB of the address space, where
+// Xtensa cores usually map I/O and ROM, neither of which can be downloaded
+// to. Pass 0x0-0xffffffff as region to not check any.
+var DefaultRegions = []Region{{0x00000000, 0xefffffff}}
+
+// VerifySrec checks a firmware in S-Record format without downloading it.
+// Records need to be S3 records with a data length which is a multiple of 4,
+// must not overlap and need to be in one of the regions, DefaultRegions if
+// regions is empty. The optional S0 header needs to be first, an S5/S6 count
+// needs to match the number of records and an S7 entry point needs to be
+// last and inside of a record. All problems found are returned as
+// *ValidationError.
+func VerifySrec(r io.Reader, regions []Region) error {
+	_, err := readSrec(r, regions)
+	return err
+}
+
+// VerifyPack checks the MT-G5321 image of a Metanoia firmware pack like
+// VerifySrec.
+func VerifyPack(r io.ReaderAt, regions []Region) error {
+	segs, _, _, err := readPack(r)
+	if err != nil {
+		return err
+	}
+	return verify(segs, nil, regions)
+}
+
+// readSrec reads and verifies a firmware in S-Record format.
+func readSrec(r io.Reader, regions []Region) ([]segment, error) {
+	var segs []segment
+	var problems []string
+	var records, count, lineNo, lastLine, n int
+	var entry uint32
+	countLine, entryLine := -1, -1
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		lineNo++
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		lastLine = lineNo
+		n++
+		typ, payload, err := srec.ParseGeneric(line)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", lineNo, err))
+			continue
+		}
+		switch typ {
+		case 0:
+			if n != 1 {
+				problems = append(problems, fmt.Sprintf("line %d: S0 header is not the first record", lineNo))
+			}
+		case 1, 2:
+			records++
+			problems = append(problems, fmt.Sprintf("line %d: S%d records are not supported, only S3", lineNo, typ))
+		case 3:
+			records++
+			if len(payload) < 4 {
+				problems = append(problems, fmt.Sprintf("line %d: S3 record without address", lineNo))
+				continue
+			}
+			segs = append(segs, segment{
+				addr: binary.BigEndian.Uint32(payload[0:4]),
+				data: payload[4:],
+				pos:  fmt.Sprintf("line %d", lineNo),
+			})
+		case 5, 6:
+			if countLine != -1 {
+				problems = append(problems, fmt.Sprintf("line %d: second S5/S6 count record", lineNo))
+			}
+			countLine = lineNo
+			if len(payload) != typ-3 {
+				problems = append(problems, fmt.Sprintf("line %d: S%d record has %d count bytes", lineNo, typ, len(payload)))
+				continue
+			}
+			count = 0
+			for _, b := range payload {
+				count = count<<8 | int(b)
+			}
+			if count != records {
+				problems = append(problems, fmt.Sprintf("line %d: S%d record counts %d records, but %d precede it", lineNo, typ, count, records))
+			}
+		case 7:
+			if entryLine != -1 {
+				problems = append(problems, fmt.Sprintf("line %d: second S7 entry point record", lineNo))
+			}
+			entryLine = lineNo
+			if len(payload) != 4 {
+				problems = append(problems, fmt.Sprintf("line %d: S7 record has %d address bytes", lineNo, len(payload)))
+				entryLine = -1
+				continue
+			}
+			entry = binary.BigEndian.Uint32(payload)
+		case 8, 9:
+			problems = append(problems, fmt.Sprintf("line %d: S%d entry point does not match S3 records", lineNo, typ))
+		default:
+			problems = append(problems, fmt.Sprintf("line %d: unknown record type S%d", lineNo, typ))
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("error reading firmware: %w", err)
+	}
+	if entryLine != -1 && entryLine != lastLine {
+		problems = append(problems, fmt.Sprintf("line %d: S7 entry point is not the last record", entryLine))
+	}
+	if entryLine != -1 {
+		var ok bool
+		for _, s := range segs {
+			ok = ok || entry >= s.addr && uint64(entry) < uint64(s.addr)+uint64(len(s.data))
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("line %d: S7 entry point %#08x is not inside of any record", entryLine, entry))
+		}
+	}
+	if err := verify(segs, problems, regions); err != nil {
+		return nil, err
+	}
+	return segs, nil
+}
+
+// verify checks segments for the problems described in VerifySrec and
+// returns all of them together with problems found before.
+func verify(segs []segment, problems []string, regions []Region) error {
+	if len(regions) == 0 {
+		regions = DefaultRegions
+	}
+	var size int
+	for _, s := range segs {
+		size += len(s.data)
+		if len(s.data)%4 != 0 {
+			problems = append(problems, fmt.Sprintf("%s: %d data bytes at %#08x are not a multiple of 4", s.pos, len(s.data), s.addr))
+		}
+		if uint64(s.addr)+uint64(len(s.data)) > 1<<32 {
+			problems = append(problems, fmt.Sprintf("%s: data at %#08x exceeds the address space", s.pos, s.addr))
+		}
+		var ok bool
+		for _, r := range regions {
+			ok = ok || r.contains(s)
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: %d bytes at %#08x are outside of all memory regions", s.pos, len(s.data), s.addr))
+		}
+	}
+	if size == 0 {
+		problems = append(problems, "image contains no data")
+	}
+
+	sorted := make([]segment, len(segs))
+	copy(sorted, segs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].addr < sorted[j].addr })
+	var prev *segment
+	for i := range sorted {
+		s := &sorted[i]
+		if len(s.data) == 0 {
+			continue
+		}
+		if prev != nil && uint64(s.addr) < uint64(prev.addr)+uint64(len(prev.data)) {
+			problems = append(problems, fmt.Sprintf("%s: data at %#08x overlaps %s (%#08x-%#08x)",
+				s.pos, s.addr, prev.pos, prev.addr, uint64(prev.addr)+uint64(len(prev.data))-1))
+		}
+		if prev == nil || uint64(s.addr)+uint64(len(s.data)) > uint64(prev.addr)+uint64(len(prev.data)) {
+			prev = s
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
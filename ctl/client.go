@@ -0,0 +1,157 @@
+package ctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// ErrClientClosed is returned for calls on a closed client or if the server
+// went away.
+var ErrClientClosed = errors.New("control connection closed")
+
+// Client is a client of a Server. It is safe for concurrent use.
+type Client struct {
+	conn io.ReadWriteCloser
+	// Events receives events of subscribed topics. It is closed when the
+	// connection shuts down. Events are dropped if it is not read.
+	Events chan Event
+
+	wmu sync.Mutex
+	enc *json.Encoder
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *message
+	closed  bool
+}
+
+// Dial connects to the server listening on the Unix socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to control socket: %w", err)
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client talking over conn.
+func NewClient(conn io.ReadWriteCloser) *Client {
+	c := &Client{
+		conn:    conn,
+		Events:  make(chan Event, eventQueueLen),
+		enc:     json.NewEncoder(conn),
+		pending: make(map[uint64]chan *message),
+	}
+	go c.reader()
+	return c
+}
+
+func (c *Client) reader() {
+	dec := json.NewDecoder(bufio.NewReader(c.conn))
+	for {
+		var m message
+		if err := dec.Decode(&m); err != nil {
+			break
+		}
+		if m.ID == nil {
+			if m.Method != methodEvent {
+				continue
+			}
+			var ev Event
+			if err := json.Unmarshal(m.Params, &ev); err != nil {
+				continue
+			}
+			select {
+			case c.Events <- ev:
+			default:
+			}
+			continue
+		}
+		// Errors for requests which could not be parsed have a null ID
+		var id uint64
+		if err := json.Unmarshal(m.ID, &id); err != nil || string(m.ID) == "null" {
+			continue
+		}
+		c.mu.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- &m
+		}
+	}
+	c.mu.Lock()
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	close(c.Events)
+}
+
+// Call calls method with params and decodes the result into result, which
+// can be nil to discard it.
+func (c *Client) Call(method string, params, result any) error {
+	var rawParams json.RawMessage
+	if params != nil {
+		var err error
+		rawParams, err = json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("failed to encode params: %w", err)
+		}
+	}
+	ch := make(chan *message, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClientClosed
+	}
+	id := c.nextID
+	c.nextID++
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	err := c.enc.Encode(&message{Version: version, ID: mustMarshal(id), Method: method, Params: rawParams})
+	c.wmu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	res, ok := <-ch
+	if !ok {
+		return ErrClientClosed
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if result != nil {
+		if err := json.Unmarshal(res.Result, result); err != nil {
+			return fmt.Errorf("failed to decode result: %w", err)
+		}
+	}
+	return nil
+}
+
+// Subscribe subscribes to topics, their events are delivered on Events.
+func (c *Client) Subscribe(topics ...string) error {
+	return c.Call(MethodSubscribe, &SubscribeParams{Topics: topics}, nil)
+}
+
+// Unsubscribe stops the delivery of events on topics.
+func (c *Client) Unsubscribe(topics ...string) error {
+	return c.Call(MethodUnsubscribe, &SubscribeParams{Topics: topics}, nil)
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,326 @@
+// Package ctl implements the ebmmanager control protocol. It is JSON-RPC 2.0
+// with one message per line, usually spoken over a Unix socket. Besides
+// normal method calls, clients can subscribe to topics and then receive
+// events as "event" notifications without polling.
+package ctl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Error codes defined by JSON-RPC 2.0.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeServerError    = -32000
+)
+
+// Error is an error returned by a remote method.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// Event is a notification published on a topic.
+type Event struct {
+	Topic string          `json:"topic"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// message is the union of requests, responses and notifications. The ID is
+// kept as sent to echo it back unchanged, it is missing for notifications.
+type message struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+const version = "2.0"
+
+// Method names handled by the server itself.
+const (
+	MethodSubscribe   = "subscribe"
+	MethodUnsubscribe = "unsubscribe"
+	methodEvent       = "event"
+)
+
+// SubscribeParams are the parameters of subscribe and unsubscribe.
+type SubscribeParams struct {
+	Topics []string `json:"topics"`
+}
+
+// Handler handles a method call. The returned value is encoded as JSON.
+// Returning an *Error sets its code, other errors are reported as
+// CodeServerError.
+type Handler func(params json.RawMessage) (any, error)
+
+// eventQueueLen is the number of events buffered per client. Events for
+// clients which fall further behind are dropped.
+const eventQueueLen = 256
+
+// Server dispatches method calls to handlers and events to subscribed
+// clients. It is safe for concurrent use.
+type Server struct {
+	mu       sync.Mutex
+	handlers map[string]Handler
+	clients  map[*serverConn]struct{}
+}
+
+// NewServer returns a server without any methods besides subscribe and
+// unsubscribe.
+func NewServer() *Server {
+	return &Server{
+		handlers: make(map[string]Handler),
+		clients:  make(map[*serverConn]struct{}),
+	}
+}
+
+// Handle registers h for method.
+func (s *Server) Handle(method string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = h
+}
+
+// Serve accepts connections on l until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+// Publish sends v as event on topic to all clients subscribed to it. It
+// never blocks.
+func (s *Server) Publish(topic string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	raw, err := json.Marshal(&message{
+		Version: version,
+		Method:  methodEvent,
+		Params:  mustMarshal(&Event{Topic: topic, Data: data}),
+	})
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		if c.subscribed(topic) {
+			select {
+			case c.events <- raw:
+			default:
+			}
+		}
+	}
+}
+
+func mustMarshal(v any) json.RawMessage {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+type serverConn struct {
+	conn   io.ReadWriteCloser
+	events chan []byte
+	done   chan struct{}
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	smu    sync.Mutex
+	topics map[string]bool
+}
+
+func (c *serverConn) subscribed(topic string) bool {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	return c.topics[topic]
+}
+
+func (c *serverConn) write(raw []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.w.Write(raw)
+	c.w.WriteByte('\n')
+	return c.w.Flush()
+}
+
+// ServeConn serves a single client until it disconnects.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	c := &serverConn{
+		conn:   conn,
+		events: make(chan []byte, eventQueueLen),
+		done:   make(chan struct{}),
+		w:      bufio.NewWriter(conn),
+		topics: make(map[string]bool),
+	}
+	s.mu.Lock()
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		close(c.done)
+		conn.Close()
+	}()
+	go func() {
+		for {
+			select {
+			case raw := <-c.events:
+				if err := c.write(raw); err != nil {
+					conn.Close()
+					return
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return
+			}
+			continue
+		}
+		var res *message
+		var req message
+		if !json.Valid(line) {
+			res = &message{Error: &Error{CodeParseError, "invalid JSON"}}
+		} else if jsonErr := json.Unmarshal(line, &req); jsonErr != nil {
+			// Answer with the ID if it can be recovered, otherwise null
+			var idOnly struct {
+				ID json.RawMessage `json:"id"`
+			}
+			json.Unmarshal(line, &idOnly)
+			res = &message{Error: &Error{CodeInvalidRequest, jsonErr.Error()}}
+			if idOnly.ID != nil && validID(idOnly.ID) {
+				res.ID = idOnly.ID
+			}
+		} else if req.ID != nil && !validID(req.ID) {
+			res = &message{Error: &Error{CodeInvalidRequest, "id needs to be a string, number or null"}}
+		} else {
+			res = s.call(c, &req)
+			if req.ID == nil {
+				// Notifications are not answered
+				if err != nil {
+					return
+				}
+				continue
+			}
+			res.ID = req.ID
+		}
+		if res.ID == nil {
+			res.ID = json.RawMessage("null")
+		}
+		res.Version = version
+		raw, mErr := json.Marshal(res)
+		if mErr != nil {
+			raw = mustMarshal(&message{Version: version, ID: res.ID, Error: &Error{CodeServerError, mErr.Error()}})
+		}
+		if wErr := c.write(raw); wErr != nil || err != nil {
+			return
+		}
+	}
+}
+
+// validID returns true if id is a JSON string, number or null as required
+// for request IDs.
+func validID(id json.RawMessage) bool {
+	var v any
+	if err := json.Unmarshal(id, &v); err != nil {
+		return false
+	}
+	switch v.(type) {
+	case string, float64, nil:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) call(c *serverConn, req *message) *message {
+	if req.Version != version || req.Method == "" {
+		return &message{Error: &Error{CodeInvalidRequest, "invalid request"}}
+	}
+	switch req.Method {
+	case MethodSubscribe, MethodUnsubscribe:
+		var p SubscribeParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return &message{Error: &Error{CodeInvalidParams, err.Error()}}
+		}
+		c.smu.Lock()
+		for _, t := range p.Topics {
+			if req.Method == MethodSubscribe {
+				c.topics[t] = true
+			} else {
+				delete(c.topics, t)
+			}
+		}
+		c.smu.Unlock()
+		return &message{Result: json.RawMessage("true")}
+	}
+	s.mu.Lock()
+	h, ok := s.handlers[req.Method]
+	s.mu.Unlock()
+	if !ok {
+		return &message{Error: &Error{CodeMethodNotFound, fmt.Sprintf("method %q not found", req.Method)}}
+	}
+	v, err := h(req.Params)
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			return &message{Error: e}
+		}
+		return &message{Error: &Error{CodeServerError, err.Error()}}
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return &message{Error: &Error{CodeServerError, fmt.Sprintf("failed to encode result: %v", err)}}
+	}
+	return &message{Result: raw}
+}
+
+// UnmarshalParams decodes params into v and returns a CodeInvalidParams error
+// if that fails. Empty params leave v untouched.
+func UnmarshalParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &Error{CodeInvalidParams, err.Error()}
+	}
+	return nil
+}
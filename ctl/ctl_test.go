@@ -0,0 +1,102 @@
+package ctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := NewServer()
+	s.Handle("add", func(params json.RawMessage) (any, error) {
+		var p []int
+		if err := UnmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		sum := 0
+		for _, v := range p {
+			sum += v
+		}
+		return sum, nil
+	})
+	go s.Serve(l)
+
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var sum int
+	if err := c.Call("add", []int{1, 2, 3}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 6 {
+		t.Errorf("expected 6, got %d", sum)
+	}
+
+	var rpcErr *Error
+	if err := c.Call("add", "nope", nil); !errors.As(err, &rpcErr) || rpcErr.Code != CodeInvalidParams {
+		t.Errorf("expected invalid params error, got %v", err)
+	}
+	if err := c.Call("missing", nil, nil); !errors.As(err, &rpcErr) || rpcErr.Code != CodeMethodNotFound {
+		t.Errorf("expected method not found error, got %v", err)
+	}
+
+	if err := c.Subscribe("status"); err != nil {
+		t.Fatal(err)
+	}
+	s.Publish("other", 1)
+	s.Publish("status", map[string]int{"ticks": 42})
+	select {
+	case ev := <-c.Events:
+		if ev.Topic != "status" || string(ev.Data) != `{"ticks":42}` {
+			t.Errorf("unexpected event %v %s", ev.Topic, ev.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+}
+
+func TestServerRaw(t *testing.T) {
+	s := NewServer()
+	s.Handle("echo", func(params json.RawMessage) (any, error) {
+		return params, nil
+	})
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(server)
+
+	r := bufio.NewReader(client)
+	cases := []struct {
+		req, res string
+	}{
+		// String IDs are echoed back unchanged
+		{`{"jsonrpc":"2.0","id":"a1","method":"echo","params":[1]}`, `{"jsonrpc":"2.0","id":"a1","result":[1]}`},
+		{`{"jsonrpc":"2.0","id":1,"method":`, `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid JSON"}}`},
+		{`{"jsonrpc":"2.0","id":2,"method":5}`, `{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"json: cannot unmarshal number into Go struct field message.method of type string"}}`},
+		{`{"jsonrpc":"2.0","id":[3],"method":"echo"}`, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"id needs to be a string, number or null"}}`},
+		// The connection is still usable after errors
+		{`{"jsonrpc":"2.0","id":4,"method":"echo","params":{}}`, `{"jsonrpc":"2.0","id":4,"result":{}}`},
+	}
+	for _, c := range cases {
+		go client.Write([]byte(c.req + "\n"))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != c.res+"\n" {
+			t.Errorf("%s: expected %s, got %s", c.req, c.res, line)
+		}
+	}
+}
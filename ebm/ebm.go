@@ -39,13 +39,16 @@ func (m *Message) MarshalBinary() ([]byte, error) {
 }
 
 func ParseMessage(data []byte) (*Message, error) {
-	if len(data) < 7 {
+	if len(data) < 8 {
 		return nil, fmt.Errorf("too short message")
 	}
 	var msg Message
 	msg.Type = data[0]
 	msg.SequenceNumber = binary.BigEndian.Uint32(data[1:5])
-	payloadLen := binary.BigEndian.Uint16(data[5:7])
+	payloadLen := int(binary.BigEndian.Uint16(data[5:7]))
+	if payloadLen+8 > len(data) {
+		return nil, fmt.Errorf("payload length %d exceeds message length %d", payloadLen, len(data))
+	}
 	msg.Status = data[7]
 	msg.Payload = data[8 : payloadLen+8]
 	return &msg, nil
@@ -128,6 +131,7 @@ const (
 	TypeConsoleOutput    = 0x60
 	TypeLoggerOutput     = 0x61
 	TypeDeviceDisconnect = 0x70
+	TypeSearchDeviceResp = 0xb0
 	TypeConnectResp      = 0xb1
 )
 
@@ -149,14 +153,44 @@ type Conn struct {
 	seqNo uint32
 
 	exchReq   chan *Message
-	exchRes   chan *Message
+	exchRes   chan exchResult
+	sendReq   chan *Message
 	exchMutex sync.Mutex
 	rxMsgChan chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
 
 	Logger          io.Writer
 	HandleChallenge func(c uint32) uint32
+	// HandleLogEvent is called for every structured log event received from
+	// the modem. If it is nil, log events are written to Logger. It is
+	// called from the receive loop and thus must not block or perform
+	// requests on the connection.
+	HandleLogEvent func(e *LogEvent)
+	// HandleConsoleOutput is called with the text of every console output
+	// message. If it is nil, console output is written to Logger. The same
+	// restrictions as for HandleLogEvent apply.
+	HandleConsoleOutput func(text []byte)
+}
+
+type exchResult struct {
+	res *Message
+	err error
 }
 
+var (
+	// ErrClosed is returned for requests on a connection which has been
+	// closed, either locally or by the modem.
+	ErrClosed = errors.New("connection has shut down")
+	// ErrNoResponse is returned if the modem did not respond to a request
+	// even after retransmissions.
+	ErrNoResponse = errors.New("no response from modem")
+)
+
+// maxRetries is the number of retransmissions of a request before giving up.
+const maxRetries = 10
+
 func DefaultChallengeHandler(c uint32) uint32 {
 	switch c {
 	case 0x95743926:
@@ -182,8 +216,10 @@ func NewConn(c *packet.Conn, addr net.HardwareAddr) *Conn {
 		addr:            addr,
 		seqNo:           2,
 		exchReq:         make(chan *Message),
-		exchRes:         make(chan *Message),
+		exchRes:         make(chan exchResult),
+		sendReq:         make(chan *Message),
 		rxMsgChan:       make(chan []byte, 10),
+		done:            make(chan struct{}),
 		HandleChallenge: DefaultChallengeHandler,
 	}
 }
@@ -197,42 +233,54 @@ func (c *Conn) listener() {
 			close(c.rxMsgChan)
 			return
 		}
-		c.rxMsgChan <- buf[:n]
+		select {
+		case c.rxMsgChan <- buf[:n]:
+		case <-c.done:
+			return
+		}
 	}
 
 }
 
 func (c *Conn) reactor() {
+	defer close(c.done)
+	// Also close the socket if the modem disconnected to not leak it
+	defer c.Close()
 	var curReq *Message
+	var retries int
 	curReqTimer := time.NewTimer(1 * time.Second)
 	curReqTimer.Stop()
 	for {
 		select {
 		case rxMsg, ok := <-c.rxMsgChan:
 			if !ok {
-				close(c.exchRes)
 				return
 			}
 			res, err := ParseMessage(rxMsg)
 			if err != nil {
 				fmt.Fprintf(c.Logger, "error parsing message, ignoring: %v\n", err)
+				continue
 			}
 			switch res.Type {
 			case TypeConsoleOutput:
-				c.Logger.Write(res.Payload)
+				if c.HandleConsoleOutput != nil {
+					c.HandleConsoleOutput(res.Payload)
+				} else {
+					c.Logger.Write(res.Payload)
+				}
 			case TypeLoggerOutput:
-				logType := binary.BigEndian.Uint16(res.Payload[20:22])
-				switch logType {
-				case 1:
-					fmt.Printf("Modem Status: %v\n", modemStatusDesc[binary.BigEndian.Uint32(res.Payload[24:28])])
-				case 4:
-					fmt.Printf("Error: %v\n", errorDesc[binary.BigEndian.Uint32(res.Payload[24:28])])
-				default:
-					fmt.Printf("Log Type %v: %x", logTypeDesc[logType], res.Payload)
+				ev, err := parseLogEvent(res.Payload)
+				if err != nil {
+					fmt.Fprintf(c.Logger, "error parsing logger output, ignoring: %v\n", err)
+					continue
+				}
+				if c.HandleLogEvent != nil {
+					c.HandleLogEvent(ev)
+				} else {
+					fmt.Fprintln(c.Logger, ev)
 				}
 			case TypeDeviceDisconnect:
 				fmt.Fprintf(c.Logger, "device disconnect, closing: %v\n", err)
-				close(c.exchReq)
 				return
 			default:
 				if curReq == nil {
@@ -242,7 +290,7 @@ func (c *Conn) reactor() {
 				if curReq.SequenceNumber != res.SequenceNumber {
 					fmt.Fprintf(c.Logger, "WARNING: Sequence number mismatch %d != %d\n", curReq.SequenceNumber, res.SequenceNumber)
 				}
-				c.exchRes <- res
+				c.exchRes <- exchResult{res: res}
 				if !curReqTimer.Stop() {
 					<-curReqTimer.C
 				}
@@ -253,31 +301,56 @@ func (c *Conn) reactor() {
 			reqRaw, err := req.MarshalBinary()
 			if err != nil {
 				fmt.Fprintf(c.Logger, "failed to marshal: %v\n", err)
-				c.exchRes <- nil
+				c.exchRes <- exchResult{err: fmt.Errorf("failed to marshal: %w", err)}
 				continue
 			}
 			if _, err := c.c.WriteTo(reqRaw, &packet.Addr{
 				HardwareAddr: c.addr,
 			}); err != nil {
 				fmt.Fprintf(c.Logger, "failed to send: %v\n", err)
-				c.exchRes <- nil
+				c.exchRes <- exchResult{err: fmt.Errorf("failed to send: %w", err)}
 				continue
 			}
 			c.seqNo++
 			curReq = req
+			retries = 0
 			curReqTimer.Reset(1 * time.Second)
+		case req := <-c.sendReq:
+			req.SequenceNumber = c.seqNo
+			reqRaw, err := req.MarshalBinary()
+			if err != nil {
+				c.exchRes <- exchResult{err: fmt.Errorf("failed to marshal: %w", err)}
+				continue
+			}
+			if _, err := c.c.WriteTo(reqRaw, &packet.Addr{
+				HardwareAddr: c.addr,
+			}); err != nil {
+				c.exchRes <- exchResult{err: fmt.Errorf("failed to send: %w", err)}
+				continue
+			}
+			c.seqNo++
+			c.exchRes <- exchResult{}
 		case <-curReqTimer.C:
+			if retries >= maxRetries {
+				fmt.Fprintf(c.Logger, "no response after %d retries, giving up\n", retries)
+				c.exchRes <- exchResult{err: ErrNoResponse}
+				curReq = nil
+				continue
+			}
+			retries++
 			fmt.Fprintf(c.Logger, "retrying send\n")
 			reqRaw, err := curReq.MarshalBinary()
 			if err != nil {
-				c.exchRes <- nil
+				c.exchRes <- exchResult{err: fmt.Errorf("failed to marshal: %w", err)}
+				curReq = nil
 				continue
 			}
 			if _, err := c.c.WriteTo(reqRaw, &packet.Addr{
 				HardwareAddr: c.addr,
 			}); err != nil {
 				fmt.Fprintf(c.Logger, "failed to send: %v\n", err)
-				c.exchRes <- nil
+				c.exchRes <- exchResult{err: fmt.Errorf("failed to send: %w", err)}
+				curReq = nil
 				continue
 			}
 			curReqTimer.Reset(1 * time.Second)
@@ -288,15 +361,99 @@ func (c *Conn) reactor() {
 func (c *Conn) Exchange(req *Message, exp uint8) (*Message, error) {
 	c.exchMutex.Lock()
 	defer c.exchMutex.Unlock()
-	c.exchReq <- req
-	res, ok := <-c.exchRes
-	if !ok {
-		return nil, errors.New("connection has shut down")
+	return c.exchange(req)
+}
+
+// exchange is Exchange without locking, callers need to hold exchMutex.
+func (c *Conn) exchange(req *Message) (*Message, error) {
+	select {
+	case c.exchReq <- req:
+	case <-c.done:
+		return nil, ErrClosed
 	}
-	if res == nil {
-		return nil, errors.New("an error occurred while processing")
+	select {
+	case r := <-c.exchRes:
+		return r.res, r.err
+	case <-c.done:
+		return nil, ErrClosed
 	}
-	return res, nil
+}
+
+// Send sends a message which is not answered by the modem.
+func (c *Conn) Send(req *Message) error {
+	c.exchMutex.Lock()
+	defer c.exchMutex.Unlock()
+	select {
+	case c.sendReq <- req:
+	case <-c.done:
+		return ErrClosed
+	}
+	select {
+	case r := <-c.exchRes:
+		return r.err
+	case <-c.done:
+		return ErrClosed
+	}
+}
+
+// WriteConsole sends text to the modem console. The modem is not known to
+// acknowledge console input, thus it is sent only once.
+func (c *Conn) WriteConsole(text []byte) error {
+	if err := c.Send(&Message{
+		Type:    TypeConsoleInput,
+		Status:  StatusDefault,
+		Payload: text,
+	}); err != nil {
+		return fmt.Errorf("failed to write console input: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connection and the underlying socket. Pending and future
+// requests return ErrClosed. It is done automatically if the modem
+// disconnects, calling it again has no effect.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.c.Close()
+	})
+	return c.closeErr
+}
+
+// Done returns a channel which is closed once the connection has shut down,
+// either because it was closed or because the modem disconnected.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Reboot asks the modem to reboot. Depending on its flash contents it comes
+// back up either in bootloader or in operational mode. As the modem might
+// reset before responding, ErrNoResponse and the connection being closed
+// after sending the request are not treated as errors. If the connection is
+// already closed, nothing can be sent and ErrClosed is returned.
+//
+// The REBOOT_UPGRADE type is only known from its name, the request is sent
+// without payload. It is not verified that the modem accepts this or which
+// payload it expects to just reboot.
+func (c *Conn) Reboot() error {
+	select {
+	case <-c.done:
+		return fmt.Errorf("failed to request reboot: %w", ErrClosed)
+	default:
+	}
+	res, err := c.Exchange(&Message{
+		Type:   TypeRebootUpgrade,
+		Status: StatusDefault,
+	}, TypeRebootUpgrade)
+	if errors.Is(err, ErrNoResponse) || errors.Is(err, ErrClosed) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to request reboot: %w", err)
+	}
+	if res.Status != StatusOk {
+		return fmt.Errorf("reboot request failed: %v", res)
+	}
+	return nil
 }
 
 func (c *Conn) connect(challangeRes, flags uint32) (*Message, error) {
@@ -335,37 +492,91 @@ func (c *Conn) Dial() error {
 }
 
 func (c *Conn) ReadMIB(o *OID) (any, error) {
+	c.exchMutex.Lock()
+	defer c.exchMutex.Unlock()
+	return c.readMIB(o)
+}
+
+// ReadMIBs reads multiple OIDs without any other request being interleaved,
+// giving a consistent snapshot as far as the modem allows. The values are
+// returned in the same order as the OIDs.
+func (c *Conn) ReadMIBs(oids ...*OID) ([]any, error) {
+	c.exchMutex.Lock()
+	defer c.exchMutex.Unlock()
+	res := make([]any, len(oids))
+	for i, o := range oids {
+		var err error
+		res[i], err = c.readMIB(o)
+		if err != nil {
+			return nil, fmt.Errorf("OID %v: %w", o.OID, err)
+		}
+	}
+	return res, nil
+}
+
+// ReadMIBsRaw is like ReadMIBs, but returns the ReadMIB response payloads,
+// which can be decoded with ParseOID or OIDValue.
+func (c *Conn) ReadMIBsRaw(oids ...*OID) ([][]byte, error) {
+	c.exchMutex.Lock()
+	defer c.exchMutex.Unlock()
+	res := make([][]byte, len(oids))
+	for i, o := range oids {
+		var err error
+		res[i], err = c.readMIBRaw(o)
+		if err != nil {
+			return nil, fmt.Errorf("OID %v: %w", o.OID, err)
+		}
+	}
+	return res, nil
+}
+
+func (c *Conn) readMIB(o *OID) (any, error) {
+	raw, err := c.readMIBRaw(o)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ParseOID(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return res, nil
+}
+
+func (c *Conn) readMIBRaw(o *OID) ([]byte, error) {
 	req, err := o.MarshalBinary()
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal OID request: %w", err)
 	}
-	resRaw, err := c.Exchange(&Message{
+	resRaw, err := c.exchange(&Message{
 		Type:    TypeReadMIB,
 		Status:  StatusDefault,
 		Payload: req,
-	}, TypeReadMIB)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to request OID: %w", err)
 	}
 	if resRaw.Status != StatusOk {
 		return nil, fmt.Errorf("failed to request OID: %v", resRaw)
 	}
-	res, err := ParseOID(resRaw.Payload)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
-	}
-	return res, nil
+	return resRaw.Payload, nil
 }
 func (c *Conn) WriteMIB(o *OID, value any) error {
+	c.exchMutex.Lock()
+	defer c.exchMutex.Unlock()
+	return c.writeMIB(o, value)
+}
+
+// writeMIB is WriteMIB without locking, callers need to hold exchMutex.
+func (c *Conn) writeMIB(o *OID, value any) error {
 	req, err := MarshalOID(o, value)
 	if err != nil {
 		return fmt.Errorf("failed to marshal OID write: %w", err)
 	}
-	resRaw, err := c.Exchange(&Message{
+	resRaw, err := c.exchange(&Message{
 		Type:    TypeWriteMIB,
 		Status:  StatusDefault,
 		Payload: req,
-	}, TypeWriteMIB)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to write OID: %w", err)
 	}
@@ -375,6 +586,56 @@ func (c *Conn) WriteMIB(o *OID, value any) error {
 	return nil
 }
 
+const (
+	LogTypeModemStatus = 1
+	LogTypeError       = 4
+)
+
+// LogEvent is a structured log entry sent by the modem in a LOGGER_OUTPUT
+// message.
+type LogEvent struct {
+	Time time.Time
+	// Type is the log type, see LogType*
+	Type uint16
+	// Value is the log type specific value, for LogTypeModemStatus the
+	// ModemStatus and for LogTypeError the error code.
+	Value uint32
+	// Payload is the full raw payload of the message.
+	Payload []byte
+}
+
+func parseLogEvent(payload []byte) (*LogEvent, error) {
+	if len(payload) < 28 {
+		return nil, fmt.Errorf("logger output too short (%d bytes)", len(payload))
+	}
+	return &LogEvent{
+		Time:    time.Now(),
+		Type:    binary.BigEndian.Uint16(payload[20:22]),
+		Value:   binary.BigEndian.Uint32(payload[24:28]),
+		Payload: payload,
+	}, nil
+}
+
+func (e *LogEvent) String() string {
+	switch e.Type {
+	case LogTypeModemStatus:
+		return fmt.Sprintf("Modem Status: %v", ModemStatus(e.Value))
+	case LogTypeError:
+		return fmt.Sprintf("Error: %v", ErrorReason(e.Value))
+	default:
+		return fmt.Sprintf("Log Type %v: %x", logTypeDesc[e.Type], e.Payload)
+	}
+}
+
+// ErrorReason returns a description of an error code as sent in
+// LogTypeError events.
+func ErrorReason(code uint32) string {
+	if desc, ok := errorDesc[code]; ok {
+		return desc
+	}
+	return fmt.Sprintf("unknown error %d", code)
+}
+
 // type (2 bytes)
 var logTypeDesc = map[uint16]string{
 	0: "eyebox",
@@ -404,10 +665,39 @@ var errorDesc = map[uint32]string{
 type ModemStatus uint32
 
 const (
-	ModemStatusIdle   = 0
-	ModemStatusSilent = 1
+	ModemStatusIdle              ModemStatus = 0
+	ModemStatusSilent            ModemStatus = 1
+	ModemStatusInitHandshake     ModemStatus = 2
+	ModemStatusInitTrain         ModemStatus = 3
+	ModemStatusShowtime          ModemStatus = 4
+	ModemStatusSelftest          ModemStatus = 5
+	ModemStatusUnitFail          ModemStatus = 6
+	ModemStatusDeactivating1     ModemStatus = 7
+	ModemStatusDeactivating2     ModemStatus = 8
+	ModemStatusInitHandshakeOnly ModemStatus = 9
+	ModemStatusInitTrainOnly     ModemStatus = 10
+	ModemStatusQuickShowtime     ModemStatus = 12
+	ModemStatusAFETXTest         ModemStatus = 13
+	ModemStatusAFERXTest         ModemStatus = 14
+	ModemStatusAFELoopback       ModemStatus = 15
 )
 
+func (s ModemStatus) String() string {
+	if desc, ok := modemStatusDesc[uint32(s)]; ok {
+		return desc
+	}
+	return fmt.Sprintf("unknown (%d)", uint32(s))
+}
+
+func (s ModemStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// IsShowtime returns true if the line is up and passing data.
+func (s ModemStatus) IsShowtime() bool {
+	return s == ModemStatusShowtime || s == ModemStatusQuickShowtime
+}
+
 // modem status (data + 24)
 var modemStatusDesc = map[uint32]string{
 	0:  "idle",
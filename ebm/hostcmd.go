@@ -0,0 +1,103 @@
+package ebm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HostCommand is a command for the line state machine of the modem, written
+// to OidHostCommand.
+type HostCommand uint8
+
+const (
+	// HostCommandIdle stops the line and puts the modem into idle. The
+	// value is taken from the note "SFP To IDLE : 0x51b0 0" of unknown
+	// origin, which pairs 0 with idle. It is not verified.
+	HostCommandIdle HostCommand = 0
+	// HostCommandStart starts training the line.
+	HostCommandStart HostCommand = 1
+)
+
+var hostCommandDesc = map[HostCommand]string{
+	HostCommandIdle:  "idle",
+	HostCommandStart: "start",
+}
+
+func (c HostCommand) String() string {
+	if desc, ok := hostCommandDesc[c]; ok {
+		return desc
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(c))
+}
+
+// HostCommandTimeout is the time to wait for the modem to acknowledge a host
+// command.
+var HostCommandTimeout = 5 * time.Second
+
+// hostCommandPollInterval is the interval in which OidCmdStatus is polled.
+const hostCommandPollInterval = 100 * time.Millisecond
+
+// ErrHostCommandTimeout is returned if the modem did not acknowledge a host
+// command within HostCommandTimeout.
+var ErrHostCommandTimeout = errors.New("modem did not acknowledge command in time")
+
+// HostCommandError is returned if running a host command fails.
+type HostCommandError struct {
+	Command HostCommand
+	Err     error
+}
+
+func (e *HostCommandError) Error() string {
+	return fmt.Sprintf("host command %v failed: %v", e.Command, e.Err)
+}
+
+func (e *HostCommandError) Unwrap() error {
+	return e.Err
+}
+
+// RunHostCommand writes the command to the modem and triggers its execution
+// by setting OidCmdStatus. It then waits up to HostCommandTimeout for the
+// modem to acknowledge it by clearing OidCmdStatus again and returns a
+// *HostCommandError wrapping ErrHostCommandTimeout otherwise. It is not
+// verified that the modem does this, so callers might only warn about a
+// timeout. No other request is interleaved with the command.
+func (c *Conn) RunHostCommand(cmd HostCommand) error {
+	c.exchMutex.Lock()
+	defer c.exchMutex.Unlock()
+	if err := c.writeMIB(&OidHostCommand, uint8(cmd)); err != nil {
+		return &HostCommandError{cmd, err}
+	}
+	if err := c.writeMIB(&OidRepeatCommand, uint8(1)); err != nil {
+		return &HostCommandError{cmd, err}
+	}
+	if err := c.writeMIB(&OidCmdStatus, true); err != nil {
+		return &HostCommandError{cmd, err}
+	}
+	deadline := time.Now().Add(HostCommandTimeout)
+	for {
+		pending, err := c.readMIB(&OidCmdStatus)
+		if err != nil {
+			return &HostCommandError{cmd, err}
+		}
+		p, ok := pending.(bool)
+		if !ok {
+			return &HostCommandError{cmd, fmt.Errorf("command status has unexpected type %T", pending)}
+		}
+		if !p {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return &HostCommandError{cmd, ErrHostCommandTimeout}
+		}
+		time.Sleep(hostCommandPollInterval)
+	}
+}
+
+// Retrain stops the line and starts training it again.
+func (c *Conn) Retrain() error {
+	if err := c.RunHostCommand(HostCommandIdle); err != nil {
+		return err
+	}
+	return c.RunHostCommand(HostCommandStart)
+}
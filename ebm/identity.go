@@ -0,0 +1,135 @@
+package ebm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Identity is the identity the modem presents to the DPU as network
+// termination (NT). Empty fields are not written and keep the modem's
+// defaults. Only the OIDs known to be writable are part of it, the xTU-R
+// version (OidXDSLTerminationUnitRemoteVersion) is not.
+type Identity struct {
+	// Vendor is the NT vendor ID (OidNetworkTerminationVendor).
+	Vendor *VendorID `json:"nt_vendor,omitempty"`
+	// Serial is the NT serial number (OidNetworkTerminationSerial).
+	Serial string `json:"nt_serial,omitempty"`
+}
+
+// IsZero returns true if no field is set.
+func (i *Identity) IsZero() bool {
+	return i.Vendor == nil && i.Serial == ""
+}
+
+// Merge returns a copy of i with all fields set in o replacing those of i.
+func (i Identity) Merge(o Identity) Identity {
+	if o.Vendor != nil {
+		i.Vendor = o.Vendor
+	}
+	if o.Serial != "" {
+		i.Serial = o.Serial
+	}
+	return i
+}
+
+// Validate checks that all values fit into their OIDs. The returned error
+// describes all problems found.
+func (i *Identity) Validate() error {
+	var problems []string
+	if i.Vendor != nil {
+		if _, err := i.Vendor.Encode(); err != nil {
+			problems = append(problems, fmt.Sprintf("nt_vendor: %v", err))
+		}
+	}
+	check := func(name, v string, o *OID) {
+		if len(v) > int(o.Length) {
+			problems = append(problems, fmt.Sprintf("%s: %d bytes long, at most %d fit", name, len(v), o.Length))
+		}
+		if strings.IndexByte(v, 0) != -1 {
+			problems = append(problems, fmt.Sprintf("%s: contains NUL bytes", name))
+		}
+	}
+	check("nt_serial", i.Serial, &OidNetworkTerminationSerial)
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid identity: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// IdentityMismatchError is returned by WriteIdentity if a value read back
+// differs from the one written.
+type IdentityMismatchError struct {
+	OID   *OID
+	Wrote string
+	Read  string
+}
+
+func (e *IdentityMismatchError) Error() string {
+	return fmt.Sprintf("%v reads back as %q after writing %q", e.OID.Name(), e.Read, e.Wrote)
+}
+
+// ReadIdentity reads the current NT identity of the modem.
+func (c *Conn) ReadIdentity() (*Identity, error) {
+	oids := []*OID{&OidNetworkTerminationVendor, &OidNetworkTerminationSerial}
+	raw, err := c.ReadMIBsRaw(oids...)
+	if err != nil {
+		return nil, err
+	}
+	vendor, err := parseVendorIDResponse(raw[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse NT vendor: %w", err)
+	}
+	m := &mibValues{oids: oids, v: make([]any, len(raw))}
+	for i := 1; i < len(raw); i++ {
+		if m.v[i], err = ParseOID(raw[i]); err != nil {
+			return nil, fmt.Errorf("failed to parse %v: %w", oids[i].Name(), err)
+		}
+	}
+	id := &Identity{Serial: mibValue[string](m, 1)}
+	if m.err != nil {
+		return nil, m.err
+	}
+	if !vendor.IsZero() {
+		id.Vendor = &vendor
+	}
+	return id, nil
+}
+
+// WriteIdentity validates and writes all set fields of the identity and
+// reads them back to verify that the modem accepted them. It needs to be
+// called before the line is started to have an effect on training.
+func (c *Conn) WriteIdentity(id *Identity) error {
+	if err := id.Validate(); err != nil {
+		return err
+	}
+	type write struct {
+		oid   *OID
+		value string
+	}
+	var writes []write
+	if id.Vendor != nil {
+		raw, _ := id.Vendor.Encode()
+		writes = append(writes, write{&OidNetworkTerminationVendor, raw})
+	}
+	if id.Serial != "" {
+		writes = append(writes, write{&OidNetworkTerminationSerial, id.Serial})
+	}
+	for _, w := range writes {
+		if err := c.WriteMIB(w.oid, w.value); err != nil {
+			return fmt.Errorf("failed to write %v: %w", w.oid.Name(), err)
+		}
+		res, err := c.ReadMIB(w.oid)
+		if err != nil {
+			return fmt.Errorf("failed to read back %v: %w", w.oid.Name(), err)
+		}
+		read, ok := res.(string)
+		if !ok {
+			return fmt.Errorf("unexpected %T value reading back %v", res, w.oid.Name())
+		}
+		// Trailing NUL bytes and spaces are trimmed when reading string OIDs.
+		if read != strings.TrimRight(w.value, "\x00 ") {
+			return &IdentityMismatchError{OID: w.oid, Wrote: w.value, Read: read}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package ebm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIdentityValidate(t *testing.T) {
+	valid := Identity{
+		Vendor: &VendorID{CountryCode: 0xb500, ProviderCode: "TSTC", VendorInfo: 0x0102},
+		Serial: "EXAMPLE-SERIAL-0001",
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected valid identity, got %v", err)
+	}
+	invalid := Identity{
+		Vendor: &VendorID{ProviderCode: "TOOLONG"},
+		Serial: strings.Repeat("x", 33),
+	}
+	err := invalid.Validate()
+	if err == nil {
+		t.Fatal("expected invalid identity")
+	}
+	for _, field := range []string{"nt_vendor", "nt_serial"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("expected error to mention %v, got %v", field, err)
+		}
+	}
+}
+
+func TestIdentityMerge(t *testing.T) {
+	vendor := &VendorID{CountryCode: 0xb500, ProviderCode: "TSTC"}
+	base := Identity{Vendor: vendor, Serial: "BASE"}
+	merged := base.Merge(Identity{Serial: "OVERRIDE"})
+	if merged.Serial != "OVERRIDE" || merged.Vendor != vendor {
+		t.Errorf("unexpected merge result %+v", merged)
+	}
+}
@@ -95,6 +95,20 @@ func ParseOID(d []byte) (any, error) {
 	}
 }
 
+// OIDValue returns the undecoded value of a ReadMIB response payload as sent
+// by the modem, without the conversion done by ParseOID.
+func OIDValue(d []byte) ([]byte, error) {
+	var req oidRequest
+	if err := binary.Read(bytes.NewReader(d), binary.BigEndian, &req); err != nil {
+		return nil, err
+	}
+	payload := d[binary.Size(&req):]
+	if uint64(req.Length) > uint64(len(payload)) {
+		return nil, fmt.Errorf("value length %d exceeds payload of %d bytes", req.Length, len(payload))
+	}
+	return payload[:req.Length], nil
+}
+
 func MarshalOID(o *OID, val any) ([]byte, error) {
 	var buf bytes.Buffer
 	if err := binary.Write(&buf, binary.BigEndian, oidRequest{
@@ -137,21 +151,6 @@ func MarshalOID(o *OID, val any) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// G.994.1 Vendor ID
-type VendorID struct {
-	CountryCode  uint16
-	ProviderCode string
-	VendorInfo   uint16
-}
-
-func ParseVendorID(vid string) VendorID {
-	return VendorID{
-		CountryCode:  binary.BigEndian.Uint16([]byte(vid[:2])),
-		ProviderCode: vid[2:6],
-		VendorInfo:   binary.BigEndian.Uint16([]byte(vid[6:])),
-	}
-}
-
 func newOIDUint32(a, b, c uint32) OID {
 	return OID{
 		OID:    [3]uint32{a, b, c},
@@ -256,7 +255,11 @@ var OidRepeatCommand = OID{
 }
 
 // SFP To IDLE : 0x51b0 0
+// This note is of unknown origin, 0x51b0 is probably a register of the SFP
+// and not an OID. Only the idle value is used, see HostCommandIdle.
 
+// OidHostCommand contains the HostCommand executed by the modem when
+// OidCmdStatus is set, see Conn.RunHostCommand.
 var OidHostCommand = OID{
 	OID:         [3]uint32{11, 1, 0},
 	Length:      1,
@@ -0,0 +1,145 @@
+package ebm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OIDNames maps the names of all known OIDs to their definitions. Names are
+// the variable names without the Oid prefix.
+var OIDNames = map[string]*OID{
+	"TxPackets":                          &OidTxPackets,
+	"TxBytes":                            &OidTxBytes,
+	"RxErrors":                           &OidRxErrors,
+	"RxPackets":                          &OidRxPackets,
+	"RxBytes":                            &OidRxBytes,
+	"Ticks":                              &OidTicks,
+	"LogControl":                         &OidLogControl,
+	"ConsoleControl":                     &OidConsoleControl,
+	"MeasuredTimeUpstream":               &OidMeasuredTimeUpstream,
+	"MeasuredTimeDownstream":             &OidMeasuredTimeDownstream,
+	"ErrorFreeBitsUpstream":              &OidErrorFreeBitsUpstream,
+	"ErrorFreeBitsDownstream":            &OidErrorFreeBitsDownstream,
+	"FarEndRetransmittedDTU":             &OidFarEndRetransmittedDTU,
+	"NearEndRetransmittedDTU":            &OidNearEndRetransmittedDTU,
+	"FarEndUncorrectedDTU":               &OidFarEndUncorrectedDTU,
+	"NearEndUncorrectedDTU":              &OidNearEndUncorrectedDTU,
+	"FarEndCodeViolations":               &OidFarEndCodeViolations,
+	"NearEndCodeViolations":              &OidNearEndCodeViolations,
+	"FailedFullInits":                    &OidFailedFullInits,
+	"FullInits":                          &OidFullInits,
+	"FarEndUnavailableSeconds":           &OidFarEndUnavailableSeconds,
+	"NearEndUnavailableSeconds":          &OidNearEndUnavailableSeconds,
+	"FarEndLossOfRMCSeconds":             &OidFarEndLossOfRMCSeconds,
+	"NearEndLossOfRMCSeconds":            &OidNearEndLossOfRMCSeconds,
+	"FarEndLossOfSignalSeconds":          &OidFarEndLossOfSignalSeconds,
+	"NearEndLossOfSignalSeconds":         &OidNearEndLossOfSignalSeconds,
+	"FarEndSeverelyErroredSeconds":       &OidFarEndSeverelyErroredSeconds,
+	"NearEndSeverelyErroredSeconds":      &OidNearEndSeverelyErroredSeconds,
+	"FarEndErroredSeconds":               &OidFarEndErroredSeconds,
+	"NearEndErroredSeconds":              &OidNearEndErroredSeconds,
+	"FarEndLossOfPower":                  &OidFarEndLossOfPower,
+	"NearEndLossOfPower":                 &OidNearEndLossOfPower,
+	"FarEndLossOfMargin":                 &OidFarEndLossOfMargin,
+	"NearEndLossOfMargin":                &OidNearEndLossOfMargin,
+	"FarEndLossOfRMC":                    &OidFarEndLossOfRMC,
+	"NearEndLossOfRMC":                   &OidNearEndLossOfRMC,
+	"FarEndLossOfSignal":                 &OidFarEndLossOfSignal,
+	"NearEndLossOfSignal":                &OidNearEndLossOfSignal,
+	"ModemStatus":                        &OidModemStatus,
+	"CmdStatus":                          &OidCmdStatus,
+	"RepeatCommand":                      &OidRepeatCommand,
+	"HostCommand":                        &OidHostCommand,
+	"NetworkTerminationSerial":           &OidNetworkTerminationSerial,
+	"NetworkTerminationVendor":           &OidNetworkTerminationVendor,
+	"DistributionPointUnitVendor":        &OidDistributionPointUnitVendor,
+	"DistributionPointUnitSerial":        &OidDistributionPointUnitSerial,
+	"FTURSelftest":                       &OidFTURSelftest,
+	"FTUOSelftest":                       &OIDFTUOSelftest,
+	"XDSLTerminationUnitRemoteVersion":   &OidXDSLTerminationUnitRemoteVersion,
+	"XDSLTerminationUnitCentralVersion":  &OidXDSLTerminationUnitCentralVersion,
+	"XDSLTerminationUnitRemoteVendor":    &OidXDSLTerminationUnitRemoteVendor,
+	"XDSLTerminationUnitCentralVendor":   &OidXDSLTerminationUnitCentralVendor,
+	"FECDTU_US":                          &OID_FECDTU_US,
+	"FECDTU_DS":                          &OID_FECDTU_DS,
+	"FECRED_US":                          &OID_FECRED_US,
+	"FECRED_DS":                          &OID_FECRED_DS,
+	"FECLEN_US":                          &OID_FECLEN_US,
+	"FECLEN_DS":                          &OID_FECLEN_DS,
+	"AttainableNetDataRateUpstream":      &OidAttainableNetDataRateUpstream,
+	"AttainableNetDataRateDownstream":    &OidAttainableNetDataRateDownstream,
+	"ExpectedThroughputRateUpstream":     &OidExpectedThroughputRateUpstream,
+	"ExpectedThroughputRateDownstream":   &OidExpectedThroughputRateDownstream,
+	"NetDataRateUpstream":                &OidNetDataRateUpstream,
+	"NetDataRateDownstream":              &OidNetDataRateDownstream,
+	"SNPRS_USb":                          &OID_SNPRS_USb,
+	"SNPRS_USa":                          &OID_SNPRS_USa,
+	"SNRSubCarrierGroupSizeUpstream":     &OidSNRSubCarrierGroupSizeUpstream,
+	"SNPRS_DSb":                          &OID_SNPRS_DSb,
+	"SNPRS_DSa":                          &OID_SNPRS_DSa,
+	"SNRSubCarrierGroupSizeDownstream":   &OidSNRSubCarrierGroupSizeDownstream,
+	"PowerUpstream":                      &OidPowerUpstream,
+	"PowerDownstream":                    &OidPowerDownstream,
+	"SignalToNoiseRatioMarginUpstream":   &OidSignalToNoiseRatioMarginUpstream,
+	"SignalToNoiseRatioMarginDownstream": &OidSignalToNoiseRatioMarginDownstream,
+	"MaxNetDataRateUpstream":             &OidMaxNetDataRateUpstream,
+	"MaxNetDataRateDownstream":           &OidMaxNetDataRateDownstream,
+}
+
+// LookupOID returns the OID with the given name (case-insensitive) or dotted
+// number like 11.21.0. For unknown dotted numbers it returns a nil OID
+// together with the parsed number so that the caller can supply the type.
+func LookupOID(s string) (*OID, [3]uint32, error) {
+	var num [3]uint32
+	for name, o := range OIDNames {
+		if strings.EqualFold(name, s) {
+			return o, o.OID, nil
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return nil, num, fmt.Errorf("unknown OID %q", s)
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, num, fmt.Errorf("invalid OID %q: %w", s, err)
+		}
+		num[i] = uint32(v)
+	}
+	for _, o := range OIDNames {
+		if o.OID == num && o.Offset == 0 {
+			return o, num, nil
+		}
+	}
+	return nil, num, nil
+}
+
+// ParseOIDType parses the name of an OID type like uint32.
+func ParseOIDType(s string) (OIDType, error) {
+	for t, name := range oidTypeDesc {
+		if name == s && t != TypeInvalid {
+			return t, nil
+		}
+	}
+	return TypeInvalid, fmt.Errorf("unknown OID type %q", s)
+}
+
+func (t OIDType) String() string {
+	if name, ok := oidTypeDesc[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type(%d)", uint32(t))
+}
+
+// Name returns the name of the OID in OIDNames or its dotted number if it is
+// not known.
+func (o *OID) Name() string {
+	for name, known := range OIDNames {
+		if known.OID == o.OID && known.Offset == o.Offset {
+			return name
+		}
+	}
+	return fmt.Sprintf("%d.%d.%d", o.OID[0], o.OID[1], o.OID[2])
+}
@@ -0,0 +1,251 @@
+package ebm
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Direction is the transmission direction on the line.
+type Direction int
+
+const (
+	Downstream Direction = iota
+	Upstream
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Downstream:
+		return "downstream"
+	case Upstream:
+		return "upstream"
+	default:
+		return fmt.Sprintf("direction(%d)", int(d))
+	}
+}
+
+func (d Direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// SubcarrierSpacing is the G.fast subcarrier spacing in Hz.
+const SubcarrierSpacing = 51.75e3
+
+// snrUnused is the raw SNR value the modem reports for subcarrier groups
+// which are not in use (no measurement, G.997.1).
+const snrUnused = 255
+
+// SNRGroup is the SNR of a single subcarrier group.
+type SNRGroup struct {
+	// Index of the subcarrier group
+	Index int `json:"index"`
+	// First subcarrier in the group
+	Subcarrier int `json:"subcarrier"`
+	// Frequency of the first subcarrier in Hz
+	Frequency float64 `json:"frequency_hz"`
+	// SNR in dB, only meaningful if Used is set
+	SNR float64 `json:"snr_db"`
+	// Used is false if the modem did not report a measurement for this
+	// group, generally because the tones are not in use.
+	Used bool `json:"used"`
+}
+
+// SNRProfile is the per-subcarrier-group SNR of one direction of the line.
+type SNRProfile struct {
+	Direction Direction  `json:"direction"`
+	GroupSize int        `json:"group_size"`
+	Groups    []SNRGroup `json:"groups"`
+}
+
+// DecodeSNRProfile decodes the raw G.997.1 SNR encoding (SNR = -32 + v/2 dB,
+// 255 meaning no measurement) as returned by the OID_SNPRS_* OIDs.
+func DecodeSNRProfile(dir Direction, groupSize uint8, raw []byte) *SNRProfile {
+	g := int(groupSize)
+	if g == 0 {
+		g = 1
+	}
+	p := SNRProfile{
+		Direction: dir,
+		GroupSize: g,
+		Groups:    make([]SNRGroup, len(raw)),
+	}
+	for i, v := range raw {
+		p.Groups[i] = SNRGroup{
+			Index:      i,
+			Subcarrier: i * g,
+			Frequency:  float64(i*g) * SubcarrierSpacing,
+			Used:       v != snrUnused,
+		}
+		if v != snrUnused {
+			p.Groups[i].SNR = -32 + float64(v)/2
+		}
+	}
+	return &p
+}
+
+// ReadSNRProfile reads and decodes the SNR of all subcarrier groups in the
+// given direction.
+func (c *Conn) ReadSNRProfile(dir Direction) (*SNRProfile, error) {
+	groupSizeOID, lowerOID, upperOID := &OidSNRSubCarrierGroupSizeDownstream, &OID_SNPRS_DSa, &OID_SNPRS_DSb
+	if dir == Upstream {
+		groupSizeOID, lowerOID, upperOID = &OidSNRSubCarrierGroupSizeUpstream, &OID_SNPRS_USa, &OID_SNPRS_USb
+	}
+	res, err := c.ReadMIB(groupSizeOID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v SNR group size: %w", dir, err)
+	}
+	groupSize, ok := res.(uint8)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %T value for %v SNR group size", res, dir)
+	}
+	var raw []byte
+	for _, o := range []*OID{lowerOID, upperOID} {
+		res, err := c.ReadMIB(o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %v SNR: %w", dir, err)
+		}
+		part, ok := res.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %T value for %v SNR", res, dir)
+		}
+		raw = append(raw, part...)
+	}
+	return DecodeSNRProfile(dir, groupSize, raw), nil
+}
+
+// WriteCSV writes the profile as CSV with a header line. SNR is left empty
+// for unused groups.
+func (p *SNRProfile) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"direction", "group", "subcarrier", "frequency_hz", "snr_db"})
+	for _, g := range p.Groups {
+		var snr string
+		if g.Used {
+			snr = strconv.FormatFloat(g.SNR, 'f', 1, 64)
+		}
+		cw.Write([]string{
+			p.Direction.String(),
+			strconv.Itoa(g.Index),
+			strconv.Itoa(g.Subcarrier),
+			strconv.FormatFloat(g.Frequency, 'f', 0, 64),
+			snr,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the profile as JSON.
+func (p *SNRProfile) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(p)
+}
+
+var snrChartColors = map[Direction]color.RGBA{
+	Downstream: {0x1f, 0x77, 0xb4, 0xff},
+	Upstream:   {0xd6, 0x27, 0x28, 0xff},
+}
+
+const (
+	snrChartWidth  = 1024
+	snrChartHeight = 400
+	snrChartMaxDB  = 64
+)
+
+// WriteSNRChart renders the given profiles into a single PNG chart with the
+// frequency on the x axis and 0 to 64 dB on the y axis. Grid lines are drawn
+// every 10 MHz and every 8 dB. Downstream is drawn in blue, upstream in red.
+func WriteSNRChart(w io.Writer, profiles ...*SNRProfile) error {
+	img := image.NewRGBA(image.Rect(0, 0, snrChartWidth, snrChartHeight))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	var maxFreq float64
+	for _, p := range profiles {
+		if len(p.Groups) > 0 {
+			last := p.Groups[len(p.Groups)-1]
+			maxFreq = math.Max(maxFreq, last.Frequency+float64(p.GroupSize)*SubcarrierSpacing)
+		}
+	}
+	if maxFreq == 0 {
+		maxFreq = 1
+	}
+	x := func(f float64) int { return int(f / maxFreq * (snrChartWidth - 1)) }
+	y := func(snr float64) int {
+		snr = math.Min(math.Max(snr, 0), snrChartMaxDB)
+		return snrChartHeight - 1 - int(snr/snrChartMaxDB*(snrChartHeight-1))
+	}
+
+	grid := color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	for f := 0.0; f < maxFreq; f += 10e6 {
+		for py := 0; py < snrChartHeight; py++ {
+			img.SetRGBA(x(f), py, grid)
+		}
+	}
+	for snr := 0.0; snr <= snrChartMaxDB; snr += 8 {
+		for px := 0; px < snrChartWidth; px++ {
+			img.SetRGBA(px, y(snr), grid)
+		}
+	}
+
+	for _, p := range profiles {
+		c := snrChartColors[p.Direction]
+		prevUsed := false
+		var px0, py0 int
+		for _, g := range p.Groups {
+			if !g.Used {
+				prevUsed = false
+				continue
+			}
+			px1, py1 := x(g.Frequency), y(g.SNR)
+			if prevUsed {
+				drawLine(img, px0, py0, px1, py1, c)
+			} else {
+				img.SetRGBA(px1, py1, c)
+			}
+			px0, py0, prevUsed = px1, py1, true
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// drawLine draws a line using Bresenham's algorithm.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.SetRGBA(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if 2*e >= dy {
+			e += dy
+			x0 += sx
+		}
+		if 2*e <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
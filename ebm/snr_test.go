@@ -0,0 +1,38 @@
+package ebm
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+)
+
+func TestDecodeSNRProfile(t *testing.T) {
+	p := DecodeSNRProfile(Upstream, 4, []byte{0, 64, 254, 255})
+	if p.GroupSize != 4 || len(p.Groups) != 4 {
+		t.Fatalf("unexpected profile shape: %+v", p)
+	}
+	expected := []float64{-32, 0, 95}
+	for i, snr := range expected {
+		if !p.Groups[i].Used || p.Groups[i].SNR != snr {
+			t.Errorf("group %d: expected %v dB, got %+v", i, snr, p.Groups[i])
+		}
+	}
+	if p.Groups[3].Used {
+		t.Errorf("group 3 should be unused")
+	}
+	if p.Groups[2].Subcarrier != 8 || p.Groups[2].Frequency != 8*SubcarrierSpacing {
+		t.Errorf("wrong subcarrier mapping for group 2: %+v", p.Groups[2])
+	}
+}
+
+func TestWriteSNRChart(t *testing.T) {
+	ds := DecodeSNRProfile(Downstream, 1, []byte{255, 100, 110, 120, 255})
+	us := DecodeSNRProfile(Upstream, 1, []byte{90, 80})
+	var buf bytes.Buffer
+	if err := WriteSNRChart(&buf, ds, us); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(&buf); err != nil {
+		t.Errorf("chart is not a valid PNG: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package ebm
+
+import (
+	"fmt"
+	"time"
+)
+
+// TickInterval is the time between two increments of OidTicks. It is not
+// verified, the millisecond is a guess based on the observed rate at which
+// the counter increases.
+var TickInterval = time.Millisecond
+
+// FECParams are the G.fast forward error correction parameters of one
+// direction.
+type FECParams struct {
+	// DTU size in codewords (Q)
+	DTUSize uint8 `json:"dtu_size"`
+	// Redundancy bytes per codeword (R)
+	Redundancy uint8 `json:"redundancy"`
+	// Codeword length in bytes (N)
+	CodewordLength uint8 `json:"codeword_length"`
+}
+
+// DirectionStatus contains the status of one direction of the line. Rates
+// are in kbit/s.
+type DirectionStatus struct {
+	NetDataRate           uint32 `json:"net_data_rate"`
+	AttainableNetDataRate uint32 `json:"attainable_net_data_rate"`
+	ExpectedThroughput    uint32 `json:"expected_throughput"`
+	// MaxNetDataRate is the configured maximum net data rate in the units
+	// the modem uses for OidMaxNetDataRate*.
+	MaxNetDataRate uint32 `json:"max_net_data_rate"`
+	// SNRMargin is the signal-to-noise ratio margin in dB.
+	SNRMargin float64 `json:"snr_margin_db"`
+	// Power is the actual aggregate transmit power in dBm.
+	Power float64   `json:"power_dbm"`
+	FEC   FECParams `json:"fec"`
+}
+
+// LineStatus is a snapshot of the most important line parameters.
+type LineStatus struct {
+	Time        time.Time   `json:"time"`
+	ModemStatus ModemStatus `json:"modem_status"`
+
+	Downstream DirectionStatus `json:"downstream"`
+	Upstream   DirectionStatus `json:"upstream"`
+
+	CentralVendor  VendorID `json:"central_vendor"`
+	CentralVersion string   `json:"central_version"`
+	RemoteVendor   VendorID `json:"remote_vendor"`
+	RemoteVersion  string   `json:"remote_version"`
+	// CentralVendorError and RemoteVendorError are set if the respective
+	// vendor ID could not be parsed, the vendor ID is zero then.
+	CentralVendorError string `json:"central_vendor_error,omitempty"`
+	RemoteVendorError  string `json:"remote_vendor_error,omitempty"`
+
+	NetworkTerminationSerial    string `json:"nt_serial"`
+	DistributionPointUnitSerial string `json:"dpu_serial"`
+
+	Ticks  uint32        `json:"ticks"`
+	Uptime time.Duration `json:"uptime"`
+}
+
+// mibValues extracts typed values from the result of ReadMIBs. The first
+// value not having the expected type is recorded in err.
+type mibValues struct {
+	oids []*OID
+	v    []any
+	err  error
+}
+
+func mibValue[T any](m *mibValues, i int) T {
+	v, ok := m.v[i].(T)
+	if !ok && m.err == nil {
+		m.err = fmt.Errorf("OID %v has type %T, expected %T", m.oids[i].OID, m.v[i], v)
+	}
+	return v
+}
+
+// tenths converts a signed value in 0.1 units as reported by uint16 OIDs.
+func (m *mibValues) tenths(i int) float64 {
+	return float64(int16(mibValue[uint32](m, i))) / 10
+}
+
+// LineStatus reads a snapshot of the line status. All values are read without
+// other requests being interleaved.
+func (c *Conn) LineStatus() (*LineStatus, error) {
+	oids := []*OID{
+		&OidModemStatus,
+		&OidTicks,
+		&OidNetDataRateDownstream,
+		&OidNetDataRateUpstream,
+		&OidAttainableNetDataRateDownstream,
+		&OidAttainableNetDataRateUpstream,
+		&OidExpectedThroughputRateDownstream,
+		&OidExpectedThroughputRateUpstream,
+		&OidMaxNetDataRateDownstream,
+		&OidMaxNetDataRateUpstream,
+		&OidSignalToNoiseRatioMarginDownstream,
+		&OidSignalToNoiseRatioMarginUpstream,
+		&OidPowerDownstream,
+		&OidPowerUpstream,
+		&OID_FECDTU_DS,
+		&OID_FECDTU_US,
+		&OID_FECRED_DS,
+		&OID_FECRED_US,
+		&OID_FECLEN_DS,
+		&OID_FECLEN_US,
+		&OidXDSLTerminationUnitCentralVendor,
+		&OidXDSLTerminationUnitCentralVersion,
+		&OidXDSLTerminationUnitRemoteVendor,
+		&OidXDSLTerminationUnitRemoteVersion,
+		&OidNetworkTerminationSerial,
+		&OidDistributionPointUnitSerial,
+	}
+	now := time.Now()
+	raw, err := c.ReadMIBsRaw(oids...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read line status: %w", err)
+	}
+	v := make([]any, len(raw))
+	for i, r := range raw {
+		// Vendor IDs are binary, trimming them like strings would change
+		// the vendor-specific information.
+		if oids[i] == &OidXDSLTerminationUnitCentralVendor || oids[i] == &OidXDSLTerminationUnitRemoteVendor {
+			v[i], err = OIDValue(r)
+		} else {
+			v[i], err = ParseOID(r)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OID %v: %w", oids[i].OID, err)
+		}
+	}
+	return parseLineStatus(now, &mibValues{oids: oids, v: v})
+}
+
+func parseLineStatus(now time.Time, m *mibValues) (*LineStatus, error) {
+	s := LineStatus{
+		Time:        now,
+		ModemStatus: ModemStatus(mibValue[uint8](m, 0)),
+		Ticks:       mibValue[uint32](m, 1),
+		Downstream: DirectionStatus{
+			NetDataRate:           mibValue[uint32](m, 2),
+			AttainableNetDataRate: mibValue[uint32](m, 4),
+			ExpectedThroughput:    mibValue[uint32](m, 6),
+			MaxNetDataRate:        mibValue[uint32](m, 8),
+			SNRMargin:             m.tenths(10),
+			Power:                 m.tenths(12),
+			FEC: FECParams{
+				DTUSize:        mibValue[uint8](m, 14),
+				Redundancy:     mibValue[uint8](m, 16),
+				CodewordLength: mibValue[uint8](m, 18),
+			},
+		},
+		Upstream: DirectionStatus{
+			NetDataRate:           mibValue[uint32](m, 3),
+			AttainableNetDataRate: mibValue[uint32](m, 5),
+			ExpectedThroughput:    mibValue[uint32](m, 7),
+			MaxNetDataRate:        mibValue[uint32](m, 9),
+			SNRMargin:             m.tenths(11),
+			Power:                 m.tenths(13),
+			FEC: FECParams{
+				DTUSize:        mibValue[uint8](m, 15),
+				Redundancy:     mibValue[uint8](m, 17),
+				CodewordLength: mibValue[uint8](m, 19),
+			},
+		},
+		CentralVersion:              mibValue[string](m, 21),
+		RemoteVersion:               mibValue[string](m, 23),
+		NetworkTerminationSerial:    mibValue[string](m, 24),
+		DistributionPointUnitSerial: mibValue[string](m, 25),
+	}
+	s.Uptime = time.Duration(s.Ticks) * TickInterval
+	centralVendor := mibValue[[]byte](m, 20)
+	remoteVendor := mibValue[[]byte](m, 22)
+	if m.err != nil {
+		return nil, fmt.Errorf("unexpected line status: %w", m.err)
+	}
+	var err error
+	if s.CentralVendor, err = ParseVendorID(string(centralVendor)); err != nil {
+		s.CentralVendorError = err.Error()
+	}
+	if s.RemoteVendor, err = ParseVendorID(string(remoteVendor)); err != nil {
+		s.RemoteVendorError = err.Error()
+	}
+	return &s, nil
+}
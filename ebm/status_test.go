@@ -0,0 +1,50 @@
+package ebm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLineStatus(t *testing.T) {
+	oids := []*OID{&OidModemStatus, &OidTicks}
+	values := []any{uint8(0), uint32(1000)}
+	for i := 2; i < 26; i++ {
+		oids = append(oids, &OidTicks)
+		switch {
+		case i == 20 || i == 22:
+			values = append(values, []byte("\xb5\x00BDCM\x00 "))
+		case i >= 20:
+			values = append(values, "")
+		case i >= 14:
+			values = append(values, uint8(i))
+		default:
+			values = append(values, uint32(i))
+		}
+	}
+	s, err := parseLineStatus(time.Now(), &mibValues{oids: oids, v: values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.CentralVendor.VendorInfo != 0x0020 {
+		t.Errorf("vendor-specific information not kept: %+v", s.CentralVendor)
+	}
+	if s.Downstream.NetDataRate != 2 || s.Upstream.FEC.CodewordLength != 19 || s.Uptime != 1000*TickInterval {
+		t.Errorf("unexpected line status %+v", s)
+	}
+
+	// An invalid vendor ID is only reported for its field
+	values[22] = []byte("123456789")
+	s, err = parseLineStatus(time.Now(), &mibValues{oids: oids, v: values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.RemoteVendorError == "" || s.CentralVendorError != "" {
+		t.Errorf("expected only the remote vendor ID to be invalid: %+v", s)
+	}
+
+	// An unexpected type must result in an error instead of a panic
+	values[1] = "1000"
+	if _, err := parseLineStatus(time.Now(), &mibValues{oids: oids, v: values}); err == nil {
+		t.Error("expected error for OID with unexpected type")
+	}
+}
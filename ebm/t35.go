@@ -0,0 +1,202 @@
+package ebm
+
+// ITU-T T.35 Annex A country codes
+var t35CountryDesc = map[uint8]string{
+	0x00: "Japan",
+	0x01: "Albania",
+	0x02: "Algeria",
+	0x03: "American Samoa",
+	0x04: "Germany",
+	0x05: "Anguilla",
+	0x06: "Antigua and Barbuda",
+	0x07: "Argentina",
+	0x08: "Ascension",
+	0x09: "Australia",
+	0x0a: "Austria",
+	0x0b: "Bahamas",
+	0x0c: "Bahrain",
+	0x0d: "Bangladesh",
+	0x0e: "Barbados",
+	0x0f: "Belgium",
+	0x10: "Belize",
+	0x11: "Benin",
+	0x12: "Bermuda",
+	0x13: "Bhutan",
+	0x14: "Bolivia",
+	0x15: "Botswana",
+	0x16: "Brazil",
+	0x17: "British Antarctic Territory",
+	0x18: "British Indian Ocean Territory",
+	0x19: "British Virgin Islands",
+	0x1a: "Brunei Darussalam",
+	0x1b: "Bulgaria",
+	0x1c: "Myanmar",
+	0x1d: "Burundi",
+	0x1e: "Belarus",
+	0x1f: "Cameroon",
+	0x20: "Canada",
+	0x21: "Cape Verde",
+	0x22: "Cayman Islands",
+	0x23: "Central African Republic",
+	0x24: "Chad",
+	0x25: "Chile",
+	0x26: "China",
+	0x27: "Colombia",
+	0x28: "Comoros",
+	0x29: "Congo",
+	0x2a: "Cook Islands",
+	0x2b: "Costa Rica",
+	0x2c: "Cuba",
+	0x2d: "Cyprus",
+	0x2e: "Czech Republic",
+	0x2f: "Cambodia",
+	0x30: "Democratic People's Republic of Korea",
+	0x31: "Denmark",
+	0x32: "Djibouti",
+	0x33: "Dominican Republic",
+	0x34: "Dominica",
+	0x35: "Ecuador",
+	0x36: "Egypt",
+	0x37: "El Salvador",
+	0x38: "Equatorial Guinea",
+	0x39: "Ethiopia",
+	0x3a: "Falkland Islands",
+	0x3b: "Fiji",
+	0x3c: "Finland",
+	0x3d: "France",
+	0x3e: "French Polynesia",
+	0x3f: "French Southern and Antarctic Lands",
+	0x40: "Gabon",
+	0x41: "Gambia",
+	0x43: "Angola",
+	0x44: "Ghana",
+	0x45: "Gibraltar",
+	0x46: "Greece",
+	0x47: "Grenada",
+	0x48: "Guam",
+	0x49: "Guatemala",
+	0x4a: "Guernsey",
+	0x4b: "Guinea",
+	0x4c: "Guinea-Bissau",
+	0x4d: "Guyana",
+	0x4e: "Haiti",
+	0x4f: "Honduras",
+	0x50: "Hong Kong",
+	0x51: "Hungary",
+	0x52: "Iceland",
+	0x53: "India",
+	0x54: "Indonesia",
+	0x55: "Iran",
+	0x56: "Iraq",
+	0x57: "Ireland",
+	0x58: "Israel",
+	0x59: "Italy",
+	0x5a: "Côte d'Ivoire",
+	0x5b: "Jamaica",
+	0x5c: "Afghanistan",
+	0x5d: "Jersey",
+	0x5e: "Jordan",
+	0x5f: "Kenya",
+	0x60: "Kiribati",
+	0x61: "Korea",
+	0x62: "Kuwait",
+	0x63: "Lao People's Democratic Republic",
+	0x64: "Lebanon",
+	0x65: "Lesotho",
+	0x66: "Liberia",
+	0x67: "Libya",
+	0x68: "Liechtenstein",
+	0x69: "Luxembourg",
+	0x6a: "Macau",
+	0x6b: "Madagascar",
+	0x6c: "Malaysia",
+	0x6d: "Malawi",
+	0x6e: "Maldives",
+	0x6f: "Mali",
+	0x70: "Malta",
+	0x71: "Mauritania",
+	0x72: "Mauritius",
+	0x73: "Mexico",
+	0x74: "Monaco",
+	0x75: "Mongolia",
+	0x76: "Montserrat",
+	0x77: "Morocco",
+	0x78: "Mozambique",
+	0x79: "Nauru",
+	0x7a: "Nepal",
+	0x7b: "Netherlands",
+	0x7c: "Netherlands Antilles",
+	0x7d: "New Caledonia",
+	0x7e: "New Zealand",
+	0x7f: "Nicaragua",
+	0x80: "Niger",
+	0x81: "Nigeria",
+	0x82: "Norway",
+	0x83: "Oman",
+	0x84: "Pakistan",
+	0x85: "Panama",
+	0x86: "Papua New Guinea",
+	0x87: "Paraguay",
+	0x88: "Peru",
+	0x89: "Philippines",
+	0x8a: "Poland",
+	0x8b: "Portugal",
+	0x8c: "Puerto Rico",
+	0x8d: "Qatar",
+	0x8e: "Romania",
+	0x8f: "Rwanda",
+	0x90: "Saint Kitts and Nevis",
+	0x91: "Saint Croix",
+	0x92: "Saint Helena and Ascension",
+	0x93: "Saint Lucia",
+	0x94: "San Marino",
+	0x95: "Saint Thomas",
+	0x96: "Sao Tome and Principe",
+	0x97: "Saint Vincent and the Grenadines",
+	0x98: "Saudi Arabia",
+	0x99: "Senegal",
+	0x9a: "Seychelles",
+	0x9b: "Sierra Leone",
+	0x9c: "Singapore",
+	0x9d: "Solomon Islands",
+	0x9e: "Somalia",
+	0x9f: "South Africa",
+	0xa0: "Spain",
+	0xa1: "Sri Lanka",
+	0xa2: "Sudan",
+	0xa3: "Suriname",
+	0xa4: "Swaziland",
+	0xa5: "Sweden",
+	0xa6: "Switzerland",
+	0xa7: "Syria",
+	0xa8: "Tanzania",
+	0xa9: "Thailand",
+	0xaa: "Togo",
+	0xab: "Tonga",
+	0xac: "Trinidad and Tobago",
+	0xad: "Tunisia",
+	0xae: "Turkey",
+	0xaf: "Turks and Caicos Islands",
+	0xb0: "Tuvalu",
+	0xb1: "Uganda",
+	0xb2: "Ukraine",
+	0xb3: "United Arab Emirates",
+	0xb4: "United Kingdom",
+	0xb5: "United States",
+	0xb6: "Burkina Faso",
+	0xb7: "Uruguay",
+	0xb8: "Russia",
+	0xb9: "Vanuatu",
+	0xba: "Vatican City",
+	0xbb: "Venezuela",
+	0xbc: "Viet Nam",
+	0xbd: "Wallis and Futuna",
+	0xbe: "Samoa",
+	0xbf: "Yemen",
+	0xc0: "Yemen",
+	0xc1: "Yugoslavia",
+	0xc2: "Democratic Republic of the Congo",
+	0xc3: "Zambia",
+	0xc4: "Zimbabwe",
+	0xfe: "Taiwan",
+}
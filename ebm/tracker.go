@@ -0,0 +1,141 @@
+package ebm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Transition is a change of the modem status.
+type Transition struct {
+	Time time.Time   `json:"time"`
+	From ModemStatus `json:"from"`
+	To   ModemStatus `json:"to"`
+	// Reason is the last error reported by the modem before the line dropped
+	// out of showtime. It is empty for all other transitions.
+	Reason string `json:"reason,omitempty"`
+}
+
+// StateTracker tracks the modem status from both OidModemStatus polling and
+// modem status log events. It is safe for concurrent use.
+type StateTracker struct {
+	// OnTransition is called for every status transition. It is called with
+	// the tracker's lock held and must not call back into the tracker.
+	OnTransition func(t Transition)
+
+	mu         sync.Mutex
+	known      bool
+	current    ModemStatus
+	since      time.Time
+	durations  map[ModemStatus]time.Duration
+	retrains   int
+	fullInits  int
+	lastError  string
+	history    []Transition
+	historyLen int
+}
+
+// NewStateTracker returns a tracker keeping the last historyLen transitions.
+func NewStateTracker(historyLen int) *StateTracker {
+	return &StateTracker{
+		durations:  make(map[ModemStatus]time.Duration),
+		historyLen: historyLen,
+	}
+}
+
+// Observe records the modem status s as seen at time at.
+func (t *StateTracker) Observe(s ModemStatus, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.known {
+		t.known, t.current, t.since = true, s, at
+		return
+	}
+	if s == t.current || at.Before(t.since) {
+		return
+	}
+	tr := Transition{Time: at, From: t.current, To: s}
+	if t.current.IsShowtime() && !s.IsShowtime() {
+		tr.Reason = t.lastError
+		t.retrains++
+	}
+	if s == ModemStatusInitHandshake {
+		t.fullInits++
+	}
+	if s.IsShowtime() {
+		t.lastError = ""
+	}
+	t.durations[t.current] += at.Sub(t.since)
+	t.current, t.since = s, at
+	t.history = append(t.history, tr)
+	if len(t.history) > t.historyLen {
+		t.history = t.history[len(t.history)-t.historyLen:]
+	}
+	if t.OnTransition != nil {
+		t.OnTransition(tr)
+	}
+}
+
+// HandleLogEvent processes modem status and error log events. It can be used
+// as Conn.HandleLogEvent.
+func (t *StateTracker) HandleLogEvent(e *LogEvent) {
+	switch e.Type {
+	case LogTypeModemStatus:
+		t.Observe(ModemStatus(e.Value), e.Time)
+	case LogTypeError:
+		t.mu.Lock()
+		t.lastError = ErrorReason(e.Value)
+		t.mu.Unlock()
+	}
+}
+
+// Poll reads OidModemStatus and records it.
+func (t *StateTracker) Poll(c *Conn) error {
+	res, err := c.ReadMIB(&OidModemStatus)
+	if err != nil {
+		return fmt.Errorf("failed to read modem status: %w", err)
+	}
+	status, ok := res.(uint8)
+	if !ok {
+		return fmt.Errorf("modem status has unexpected type %T", res)
+	}
+	t.Observe(ModemStatus(status), time.Now())
+	return nil
+}
+
+// TrackerState is a snapshot of a StateTracker.
+type TrackerState struct {
+	Current ModemStatus `json:"current"`
+	Since   time.Time   `json:"since"`
+	// TimeInState is the total time spent in each status, including the
+	// current one.
+	TimeInState map[ModemStatus]time.Duration `json:"time_in_state"`
+	// Retrains is the number of times the line dropped out of showtime.
+	Retrains int `json:"retrains"`
+	// FullInits is the number of times a full initialization was started.
+	FullInits int          `json:"full_inits"`
+	History   []Transition `json:"history"`
+}
+
+// State returns a snapshot of the tracker. It returns nil if no status has
+// been observed yet.
+func (t *StateTracker) State() *TrackerState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.known {
+		return nil
+	}
+	s := TrackerState{
+		Current:     t.current,
+		Since:       t.since,
+		TimeInState: make(map[ModemStatus]time.Duration, len(t.durations)+1),
+		Retrains:    t.retrains,
+		FullInits:   t.fullInits,
+		History:     append([]Transition(nil), t.history...),
+	}
+	for k, v := range t.durations {
+		s.TimeInState[k] = v
+	}
+	s.TimeInState[t.current] += time.Since(t.since)
+	return &s
+}
@@ -0,0 +1,41 @@
+package ebm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateTracker(t *testing.T) {
+	tr := NewStateTracker(2)
+	base := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	tr.Observe(ModemStatusIdle, base)
+	tr.Observe(ModemStatusInitHandshake, base.Add(1*time.Second))
+	tr.Observe(ModemStatusShowtime, base.Add(60*time.Second))
+	tr.HandleLogEvent(&LogEvent{Type: LogTypeError, Value: 11})
+	tr.HandleLogEvent(&LogEvent{Type: LogTypeModemStatus, Value: uint32(ModemStatusSilent), Time: base.Add(120 * time.Second)})
+
+	s := tr.State()
+	if s.Current != ModemStatusSilent {
+		t.Errorf("expected silent, got %v", s.Current)
+	}
+	if s.Retrains != 1 || s.FullInits != 1 {
+		t.Errorf("expected 1 retrain and 1 full init, got %d/%d", s.Retrains, s.FullInits)
+	}
+	if len(s.History) != 2 {
+		t.Fatalf("history not bounded, got %d entries", len(s.History))
+	}
+	if last := s.History[1]; last.From != ModemStatusShowtime || last.Reason != "high BER event" {
+		t.Errorf("unexpected last transition %+v", last)
+	}
+	if s.TimeInState[ModemStatusInitHandshake] != 59*time.Second {
+		t.Errorf("wrong time in init handshake: %v", s.TimeInState[ModemStatusInitHandshake])
+	}
+}
+
+func TestModemStatusString(t *testing.T) {
+	for code, desc := range modemStatusDesc {
+		if ModemStatus(code).String() != desc {
+			t.Errorf("wrong description for %d", code)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package ebm
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// VendorID is a G.994.1 vendor ID as carried by the *Vendor OIDs. On the wire
+// it is 8 bytes long: a 2-byte ITU-T T.35 country code, a 4-character
+// provider code and 2 bytes of vendor-specific information.
+type VendorID struct {
+	CountryCode  uint16
+	ProviderCode string
+	VendorInfo   uint16
+}
+
+// vendorIDLen is the length of an encoded VendorID.
+const vendorIDLen = 8
+
+// ParseVendorID parses the raw value of one of the *Vendor OIDs, see
+// ReadVendorID. Values read with ReadMIB have trailing zero bytes and spaces
+// trimmed, which can change the vendor-specific information. For these,
+// shorter values are zero-padded. An empty value returns a zero VendorID.
+func ParseVendorID(vid string) (VendorID, error) {
+	if len(vid) > vendorIDLen {
+		return VendorID{}, fmt.Errorf("vendor ID is %d bytes long, expected at most %d", len(vid), vendorIDLen)
+	}
+	var raw [vendorIDLen]byte
+	copy(raw[:], vid)
+	return VendorID{
+		CountryCode:  binary.BigEndian.Uint16(raw[0:2]),
+		ProviderCode: string(raw[2:6]),
+		VendorInfo:   binary.BigEndian.Uint16(raw[6:8]),
+	}, nil
+}
+
+// Encode encodes the vendor ID for writing it to one of the writable *Vendor
+// OIDs.
+func (v VendorID) Encode() (string, error) {
+	if len(v.ProviderCode) != 4 {
+		return "", fmt.Errorf("provider code %q needs to be exactly 4 bytes long", v.ProviderCode)
+	}
+	var raw [vendorIDLen]byte
+	binary.BigEndian.PutUint16(raw[0:2], v.CountryCode)
+	copy(raw[2:6], v.ProviderCode)
+	binary.BigEndian.PutUint16(raw[6:8], v.VendorInfo)
+	return string(raw[:]), nil
+}
+
+// IsZero returns true if no vendor ID is set.
+func (v VendorID) IsZero() bool {
+	return v.CountryCode == 0 && v.VendorInfo == 0 && (v.ProviderCode == "" || v.ProviderCode == "\x00\x00\x00\x00")
+}
+
+// Country returns the name of the country the vendor ID is registered in or
+// an empty string if it is unknown. The first byte is the T.35 country code.
+// A first byte of 0xff indicates that the second byte is a country code
+// extension, which is not decoded.
+func (v VendorID) Country() string {
+	if v.CountryCode>>8 == 0xff {
+		return ""
+	}
+	return t35CountryDesc[uint8(v.CountryCode>>8)]
+}
+
+// Vendor returns the name of the chipset vendor identified by the provider
+// code or an empty string if it is unknown.
+func (v VendorID) Vendor() string {
+	return providerCodeDesc[v.ProviderCode]
+}
+
+// VendorInfoString returns the vendor-specific information. Its meaning is
+// defined by each vendor, it is generally used for a chipset or firmware
+// revision and formatted as such.
+func (v VendorID) VendorInfoString() string {
+	return fmt.Sprintf("%d.%d", v.VendorInfo>>8, v.VendorInfo&0xff)
+}
+
+func (v VendorID) String() string {
+	if v.IsZero() {
+		return "none"
+	}
+	country := v.Country()
+	if country == "" {
+		country = fmt.Sprintf("country 0x%04x", v.CountryCode)
+	}
+	vendor := v.Vendor()
+	if vendor == "" {
+		vendor = fmt.Sprintf("%q", v.ProviderCode)
+	}
+	return fmt.Sprintf("%s (%s) rev %s", vendor, country, v.VendorInfoString())
+}
+
+type vendorIDJSON struct {
+	CountryCode  uint16 `json:"country_code"`
+	Country      string `json:"country,omitempty"`
+	ProviderCode string `json:"provider_code"`
+	Vendor       string `json:"vendor,omitempty"`
+	VendorInfo   uint16 `json:"vendor_info"`
+}
+
+func (v VendorID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(vendorIDJSON{
+		CountryCode:  v.CountryCode,
+		Country:      v.Country(),
+		ProviderCode: v.ProviderCode,
+		Vendor:       v.Vendor(),
+		VendorInfo:   v.VendorInfo,
+	})
+}
+
+func (v *VendorID) UnmarshalJSON(data []byte) error {
+	var j vendorIDJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*v = VendorID{CountryCode: j.CountryCode, ProviderCode: j.ProviderCode, VendorInfo: j.VendorInfo}
+	return nil
+}
+
+// ReadVendorID reads and parses a vendor ID OID. The value is decoded from
+// the raw bytes sent by the modem.
+func (c *Conn) ReadVendorID(o *OID) (VendorID, error) {
+	res, err := c.ReadMIBsRaw(o)
+	if err != nil {
+		return VendorID{}, err
+	}
+	return parseVendorIDResponse(res[0])
+}
+
+// parseVendorIDResponse parses a vendor ID from a ReadMIB response payload.
+func parseVendorIDResponse(res []byte) (VendorID, error) {
+	raw, err := OIDValue(res)
+	if err != nil {
+		return VendorID{}, err
+	}
+	return ParseVendorID(string(raw))
+}
+
+// WriteVendorID encodes and writes a vendor ID OID.
+func (c *Conn) WriteVendorID(o *OID, v VendorID) error {
+	raw, err := v.Encode()
+	if err != nil {
+		return err
+	}
+	return c.WriteMIB(o, raw)
+}
+
+// G.994.1 provider codes of known chipset vendors (non-exhaustive)
+var providerCodeDesc = map[string]string{
+	"ALCB": "Alcatel",
+	"ANDV": "Analog Devices",
+	"BDCM": "Broadcom",
+	"CENT": "Centillium",
+	"CXSY": "Conexant",
+	"GSPN": "Globespan",
+	"IFTN": "Infineon/Lantiq",
+	"IKNS": "Ikanos",
+	"MTNO": "Metanoia",
+	"STMI": "STMicroelectronics",
+	"TMMB": "Thomson",
+	"TSTC": "Texas Instruments",
+}
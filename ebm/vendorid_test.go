@@ -0,0 +1,64 @@
+package ebm
+
+import "testing"
+
+func TestParseVendorID(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected VendorID
+	}{
+		{"\xb5\x00BDCM\xa4\x3f", VendorID{0xb500, "BDCM", 0xa43f}},
+		// Trailing zeroes are trimmed when reading string OIDs
+		{"\xb5\x00BDCM", VendorID{0xb500, "BDCM", 0}},
+		{"\xfe\x00MT", VendorID{0xfe00, "MT\x00\x00", 0}},
+		{"", VendorID{0, "\x00\x00\x00\x00", 0}},
+	}
+	for _, c := range cases {
+		got, err := ParseVendorID(c.in)
+		if err != nil {
+			t.Errorf("%q: %v", c.in, err)
+			continue
+		}
+		if got != c.expected {
+			t.Errorf("%q: expected %+v, got %+v", c.in, c.expected, got)
+		}
+	}
+	if _, err := ParseVendorID("123456789"); err == nil {
+		t.Error("expected error for too long vendor ID")
+	}
+}
+
+func TestVendorIDDecode(t *testing.T) {
+	v, _ := ParseVendorID("\xb5\x00BDCM\xa4\x3f")
+	if v.Country() != "United States" || v.Vendor() != "Broadcom" {
+		t.Errorf("wrong decode: %v", v)
+	}
+	enc, err := v.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc != "\xb5\x00BDCM\xa4\x3f" {
+		t.Errorf("wrong encoding %x", enc)
+	}
+	if v, _ := ParseVendorID("\xff\x04BDCM\x00\x00"); v.Country() != "" {
+		t.Errorf("country with extension byte should not be decoded, got %q", v.Country())
+	}
+	if v, _ := ParseVendorID(""); !v.IsZero() || v.String() != "none" {
+		t.Errorf("empty vendor ID should be zero, got %v", v)
+	}
+}
+
+func TestParseVendorIDResponse(t *testing.T) {
+	req, _ := OidXDSLTerminationUnitCentralVendor.MarshalBinary()
+	// Trailing spaces and zero bytes are kept when decoding the raw value
+	v, err := parseVendorIDResponse(append(req, "\xb5\x00BDCM\x20\x00"...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.VendorInfo != 0x2000 {
+		t.Errorf("expected vendor info 0x2000, got %#04x", v.VendorInfo)
+	}
+	if _, err := parseVendorIDResponse(req); err == nil {
+		t.Error("expected error for truncated response")
+	}
+}
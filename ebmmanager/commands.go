@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/bootloader"
+	"git.dolansoft.org/lorenz/metanoia-ebm/ebm"
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+)
+
+func bootMain(args []string) {
+	fs := flag.NewFlagSet("boot", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	force := fs.Bool("force", false, "Download the firmware without probing whether the modem already runs one")
+	fs.Parse(args)
+	cfg := f.load(true)
+	s := newSession(cfg)
+	start := time.Now()
+	downloaded := true
+	var err error
+	if *force {
+		err = s.boot()
+	} else {
+		downloaded, err = s.ensureBooted()
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *f.json {
+		printJSON(struct {
+			Interface  string              `json:"interface"`
+			MAC        string              `json:"mac"`
+			Downloaded bool                `json:"downloaded"`
+			Duration   time.Duration       `json:"duration"`
+			Download   *bootloader.Summary `json:"download,omitempty"`
+		}{cfg.Interface, s.addr.String(), downloaded, time.Since(start), s.lastBoot})
+		return
+	}
+	if !downloaded {
+		fmt.Printf("Modem already running with MAC %v\n", s.addr)
+		return
+	}
+	fmt.Printf("Modem booted with MAC %v in %v\n", s.addr, time.Since(start).Round(time.Millisecond))
+}
+
+func verifyFirmwareMain(args []string) {
+	fs := flag.NewFlagSet("verify-fw", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to the JSON configuration file")
+	fw := fs.String("fw", "", "Path to the firmware pack or Motorola S-REC file (overrides config)")
+	var regionFlags []string
+	fs.Func("region", "Memory region records need to be in, like 0x0-0xfffff (repeatable, overrides config)", func(s string) error {
+		regionFlags = append(regionFlags, s)
+		return nil
+	})
+	jsonOut := fs.Bool("json", false, "Output JSON for scripting")
+	fs.Parse(args)
+	cfg := defaultConfig()
+	if *configPath != "" {
+		var err error
+		if cfg, err = loadConfig(*configPath); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *fw != "" {
+		cfg.Firmware = *fw
+	}
+	if regionFlags != nil {
+		cfg.FirmwareRegions = regionFlags
+	}
+	if cfg.Firmware == "" {
+		log.Fatalln("firmware needs to be set")
+	}
+	regions, err := cfg.firmwareRegions()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	f, err := os.Open(cfg.Firmware)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+	if fwpack.IsPack(f) {
+		err = bootloader.VerifyPack(f, regions)
+	} else {
+		err = bootloader.VerifySrec(f, regions)
+	}
+	var problems []string
+	var verr *bootloader.ValidationError
+	if errors.As(err, &verr) {
+		problems = verr.Problems
+	} else if err != nil {
+		log.Fatalln(err)
+	}
+	if *jsonOut {
+		printJSON(struct {
+			Firmware string   `json:"firmware"`
+			Valid    bool     `json:"valid"`
+			Problems []string `json:"problems"`
+		}{cfg.Firmware, len(problems) == 0, problems})
+	} else if len(problems) == 0 {
+		fmt.Printf("%v: no problems found\n", cfg.Firmware)
+	} else {
+		fmt.Printf("%v: %d problems found\n", cfg.Firmware, len(problems))
+		for _, p := range problems {
+			fmt.Printf("  %v\n", p)
+		}
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+func probeMain(args []string) {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	fs.Parse(args)
+	s := newSession(f.load(false))
+	res, err := s.probe()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *f.json {
+		mac := ""
+		if res.Addr != nil {
+			mac = res.Addr.String()
+		}
+		printJSON(struct {
+			Mode bootloader.Mode `json:"mode"`
+			MAC  string          `json:"mac,omitempty"`
+		}{res.Mode, mac})
+		return
+	}
+	if res.Addr != nil {
+		fmt.Printf("Modem is %v at %v\n", res.Mode, res.Addr)
+	} else {
+		fmt.Printf("Modem is %v\n", res.Mode)
+	}
+}
+
+// oidFlags are flags describing OIDs which are not known by name.
+type oidFlags struct {
+	typ    *string
+	length *uint
+	offset *uint
+}
+
+func addOIDFlags(fs *flag.FlagSet) *oidFlags {
+	return &oidFlags{
+		typ:    fs.String("type", "", "Type of an unknown OID (uint32, uint16, uint8, string or bool)"),
+		length: fs.Uint("length", 1, "Length of an unknown OID in values"),
+		offset: fs.Uint("offset", 0, "Offset of an unknown OID in values"),
+	}
+}
+
+// oidRef references an OID by name or dotted number. Type, Length and
+// Offset describe unknown ones.
+type oidRef struct {
+	OID    string `json:"oid"`
+	Type   string `json:"type,omitempty"`
+	Length uint32 `json:"length,omitempty"`
+	Offset uint32 `json:"offset,omitempty"`
+}
+
+func (f *oidFlags) ref(s string) oidRef {
+	return oidRef{OID: s, Type: *f.typ, Length: uint32(*f.length), Offset: uint32(*f.offset)}
+}
+
+// resolve resolves the reference to an OID.
+func (r *oidRef) resolve() (*ebm.OID, error) {
+	o, num, err := ebm.LookupOID(r.OID)
+	if err != nil {
+		return nil, err
+	}
+	if o != nil {
+		return o, nil
+	}
+	if r.Type == "" {
+		return nil, fmt.Errorf("OID %v is not known, its type needs to be set", r.OID)
+	}
+	typ, err := ebm.ParseOIDType(r.Type)
+	if err != nil {
+		return nil, err
+	}
+	length := r.Length
+	if length == 0 {
+		length = 1
+	}
+	return &ebm.OID{OID: num, Type: typ, Length: length, Offset: r.Offset}, nil
+}
+
+// formatValue formats an OID value from an oidResult. Numbers decoded from
+// the daemon's JSON are float64, print them without exponent.
+func formatValue(v any) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// parseValue parses s as a value of the OID's type.
+func parseValue(o *ebm.OID, s string) (any, error) {
+	switch o.Type {
+	case ebm.TypeUint32:
+		v, err := strconv.ParseUint(s, 0, 32)
+		return uint32(v), err
+	case ebm.TypeUint16:
+		v, err := strconv.ParseUint(s, 0, 16)
+		return uint16(v), err
+	case ebm.TypeUint8:
+		if o.Length == 1 {
+			v, err := strconv.ParseUint(s, 0, 8)
+			return uint8(v), err
+		}
+		v, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(v) > int(o.Length) {
+			return nil, fmt.Errorf("value is %d bytes, OID only holds %d", len(v), o.Length)
+		}
+		return append(v, make([]byte, int(o.Length)-len(v))...), nil
+	case ebm.TypeString:
+		if len(s) > int(o.Length) {
+			return nil, fmt.Errorf("value is %d bytes, OID only holds %d", len(s), o.Length)
+		}
+		return s, nil
+	case ebm.TypeBool:
+		return strconv.ParseBool(s)
+	default:
+		return nil, fmt.Errorf("writing %v OIDs is not supported", o.Type)
+	}
+}
+
+type oidResult struct {
+	OID   string `json:"oid"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+func newOIDResult(o *ebm.OID, v any) *oidResult {
+	res := &oidResult{
+		OID:   fmt.Sprintf("%d.%d.%d", o.OID[0], o.OID[1], o.OID[2]),
+		Name:  o.Name(),
+		Type:  o.Type.String(),
+		Value: v,
+	}
+	if b, ok := v.([]byte); ok {
+		res.Value = hex.EncodeToString(b)
+	}
+	return res
+}
+
+// readOID reads the referenced OID on c.
+func readOID(c *ebm.Conn, ref oidRef) (*oidResult, error) {
+	o, err := ref.resolve()
+	if err != nil {
+		return nil, err
+	}
+	v, err := c.ReadMIB(o)
+	if err != nil {
+		return nil, err
+	}
+	return newOIDResult(o, v), nil
+}
+
+// setParams are the parameters of the set method.
+type setParams struct {
+	oidRef
+	Value string `json:"value"`
+}
+
+// writeOID parses and writes the value to the referenced OID on c.
+func writeOID(c *ebm.Conn, p setParams) error {
+	o, err := p.resolve()
+	if err != nil {
+		return err
+	}
+	v, err := parseValue(o, p.Value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %v: %w", o.Name(), err)
+	}
+	return c.WriteMIB(o, v)
+}
+
+func getMain(args []string) {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	of := addOIDFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s get [flags] <oid>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	ref := of.ref(fs.Arg(0))
+	var res *oidResult
+	if cl := f.client(); cl != nil {
+		defer cl.Close()
+		if err := cl.Call("get", &ref, &res); err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		c, err := newSession(f.load(false)).dial()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer c.Close()
+		res, err = readOID(c, ref)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *f.json {
+		printJSON(res)
+		return
+	}
+	fmt.Println(formatValue(res.Value))
+}
+
+func setMain(args []string) {
+	fs := flag.NewFlagSet("set", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	of := addOIDFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s set [flags] <oid> <value>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	p := setParams{oidRef: of.ref(fs.Arg(0)), Value: fs.Arg(1)}
+	if cl := f.client(); cl != nil {
+		defer cl.Close()
+		if err := cl.Call("set", &p, nil); err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		c, err := newSession(f.load(false)).dial()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer c.Close()
+		if err := writeOID(c, p); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *f.json {
+		printJSON(struct {
+			OID string `json:"oid"`
+			OK  bool   `json:"ok"`
+		}{p.OID, true})
+	}
+}
+
+func statusMain(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	fs.Parse(args)
+	var status *ebm.LineStatus
+	if cl := f.client(); cl != nil {
+		defer cl.Close()
+		if err := cl.Call("status", nil, &status); err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		c, err := newSession(f.load(false)).dial()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer c.Close()
+		status, err = c.LineStatus()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *f.json {
+		printJSON(status)
+		return
+	}
+	printStatus(status)
+}
+
+// vendorString formats a vendor ID of a LineStatus or its parse error.
+func vendorString(v ebm.VendorID, parseErr string) string {
+	if parseErr != "" {
+		return "invalid (" + parseErr + ")"
+	}
+	return v.String()
+}
+
+func printStatus(s *ebm.LineStatus) {
+	fmt.Printf("Modem status: %v (uptime %v)\n", s.ModemStatus, s.Uptime.Round(time.Second))
+	fmt.Printf("Central:      %v, version %q\n", vendorString(s.CentralVendor, s.CentralVendorError), s.CentralVersion)
+	fmt.Printf("Remote:       %v, version %q\n", vendorString(s.RemoteVendor, s.RemoteVendorError), s.RemoteVersion)
+	fmt.Printf("NT serial:    %q\n", s.NetworkTerminationSerial)
+	fmt.Printf("DPU serial:   %q\n\n", s.DistributionPointUnitSerial)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tDownstream\tUpstream")
+	row := func(name string, f func(d *ebm.DirectionStatus) any) {
+		fmt.Fprintf(tw, "%s\t%v\t%v\n", name, f(&s.Downstream), f(&s.Upstream))
+	}
+	row("Net data rate (kbit/s)", func(d *ebm.DirectionStatus) any { return d.NetDataRate })
+	row("Attainable rate (kbit/s)", func(d *ebm.DirectionStatus) any { return d.AttainableNetDataRate })
+	row("Expected throughput (kbit/s)", func(d *ebm.DirectionStatus) any { return d.ExpectedThroughput })
+	row("Max net data rate", func(d *ebm.DirectionStatus) any { return d.MaxNetDataRate })
+	row("SNR margin (dB)", func(d *ebm.DirectionStatus) any { return d.SNRMargin })
+	row("Transmit power (dBm)", func(d *ebm.DirectionStatus) any { return d.Power })
+	row("FEC N/R/Q", func(d *ebm.DirectionStatus) any {
+		return fmt.Sprintf("%d/%d/%d", d.FEC.CodewordLength, d.FEC.Redundancy, d.FEC.DTUSize)
+	})
+	tw.Flush()
+}
+
+func identityMain(args []string) {
+	fs := flag.NewFlagSet("identity", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	apply := fs.Bool("apply", false, "Write the configured identity, it takes effect on the next line start")
+	fs.Parse(args)
+	cfg := f.load(false)
+	c, err := newSession(cfg).dial()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer c.Close()
+	if *apply {
+		id, _ := cfg.identity()
+		if err := c.WriteIdentity(&id); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	id, err := c.ReadIdentity()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *f.json {
+		printJSON(id)
+		return
+	}
+	vendor := "none"
+	if id.Vendor != nil {
+		vendor = id.Vendor.String()
+	}
+	fmt.Printf("NT vendor: %v\n", vendor)
+	fmt.Printf("NT serial: %q\n", id.Serial)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/bootloader"
+	"git.dolansoft.org/lorenz/metanoia-ebm/ebm"
+)
+
+// Config is the ebmmanager configuration file. It is encoded as JSON.
+type Config struct {
+	// Interface is the network interface the modem is connected to.
+	Interface string `json:"interface"`
+	// Firmware is the path to the firmware, either a Metanoia firmware pack
+	// (firmware_package.b) or extracted in Motorola S-REC format.
+	Firmware string `json:"firmware"`
+	// FirmwareRegions are memory regions (like "0x0-0xfffff") all firmware
+	// records need to be in. The provisional bootloader.DefaultRegions are
+	// used if empty.
+	FirmwareRegions []string `json:"firmware_regions"`
+	// DownloadChecksum selects the checksum sent at the end of the firmware
+	// download: "firmware" (derived from the firmware, the default),
+	// "stored" (from the firmware pack), "records" (the unverified CRC-32
+	// over the sent records), "known" (the value of the original
+	// implementation, which only matches one firmware build) or a fixed
+	// value.
+	DownloadChecksum string `json:"download_checksum"`
+	// KeepRecords sends every S-Record as its own download record instead
+	// of merging contiguous ones into fewer, larger records.
+	KeepRecords bool `json:"keep_records"`
+	// MAC is the address assigned to the modem. If empty, it is derived
+	// from the interface address and ModemID.
+	MAC string `json:"mac"`
+	// ModemID distinguishes multiple modems attached to the same interface
+	// when deriving their MAC, for example the SFP serial number.
+	ModemID string `json:"modem_id"`
+	// Challenges maps connection questions to answers, both as integers or
+	// hex strings. The built-in answers are used for unknown questions.
+	Challenges map[string]hexUint32 `json:"challenges"`
+	// LogMask enables structured logger output types.
+	LogMask hexUint32 `json:"log_mask"`
+	// ConsoleLevel sets the verbosity of the modem console output.
+	ConsoleLevel hexUint32 `json:"console_level"`
+	// Identity is written to the modem before the line is started. Values
+	// set here override those of the selected identity profile.
+	Identity ebm.Identity `json:"identity"`
+	// IdentityProfile selects one of IdentityProfiles.
+	IdentityProfile string `json:"identity_profile"`
+	// IdentityProfiles are named identities, for example one per ISP CPE
+	// model.
+	IdentityProfiles map[string]ebm.Identity `json:"identity_profiles"`
+	// RateCaps limit the maximum net data rate of the line.
+	RateCaps RateCapsConfig `json:"rate_caps"`
+
+	// PollInterval is the interval in which the watchdog checks the modem.
+	PollInterval duration `json:"poll_interval"`
+	// StallChecks is the number of polls without the ticks incrementing
+	// before the modem is considered stuck.
+	StallChecks int `json:"stall_checks"`
+	// Recovery is the watchdog recovery ladder, see recoverySteps.
+	Recovery []string `json:"recovery"`
+	// StatusInterval is the interval in which status and PM counters are
+	// read.
+	StatusInterval duration `json:"status_interval"`
+	// PMState is the path to the performance monitoring state, PM is
+	// disabled if empty.
+	PMState string `json:"pm_state"`
+	// Socket is the path of the control socket served by the daemon.
+	Socket string     `json:"socket"`
+	HTTP   HTTPConfig `json:"http"`
+
+	Exporters ExportersConfig `json:"exporters"`
+}
+
+// RateCapsConfig contains maximum net data rates in the units of
+// OidMaxNetDataRate*. Zero values are not written.
+type RateCapsConfig struct {
+	Downstream uint16 `json:"downstream"`
+	Upstream   uint16 `json:"upstream"`
+}
+
+// HTTPConfig configures the HTTP status page and REST API.
+type HTTPConfig struct {
+	// Listen is the address to listen on, like "127.0.0.1:8080". The HTTP
+	// server is disabled if empty.
+	Listen string `json:"listen"`
+	// Token authenticates POST requests as bearer token. POST requests are
+	// rejected if empty.
+	Token string `json:"token"`
+}
+
+// ExportersConfig selects which exporters are enabled.
+type ExportersConfig struct {
+	// Log prints the ticks and modem status to stdout.
+	Log bool `json:"log"`
+	// MQTT publishes the line status to an MQTT broker if set.
+	MQTT *MQTTConfig `json:"mqtt"`
+}
+
+// MQTTConfig configures the MQTT exporter.
+type MQTTConfig struct {
+	// Broker is the TCP address of the broker, like "localhost:1883".
+	Broker   string `json:"broker"`
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// TopicPrefix is prepended to all topics. It defaults to
+	// "ebmmanager/<configured or derived modem MAC without colons>".
+	TopicPrefix string `json:"topic_prefix"`
+	// DiscoveryPrefix is the Home Assistant MQTT discovery prefix. Discovery
+	// configs are not published if empty.
+	DiscoveryPrefix string `json:"discovery_prefix"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		LogMask:          0xfe,
+		ConsoleLevel:     2,
+		PollInterval:     duration(5 * time.Second),
+		StallChecks:      3,
+		Recovery:         []string{"reconnect", "reboot", "redownload"},
+		DownloadChecksum: "firmware",
+		StatusInterval:   duration(5 * time.Second),
+		Socket:           "/run/ebmmanager.sock",
+		Exporters: ExportersConfig{
+			Log: true,
+		},
+	}
+}
+
+// loadConfig reads the configuration file at path on top of the defaults.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %v: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// firmwareRegions parses FirmwareRegions.
+func (c *Config) firmwareRegions() ([]bootloader.Region, error) {
+	var regions []bootloader.Region
+	for _, r := range c.FirmwareRegions {
+		region, err := bootloader.ParseRegion(r)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// Validate checks the configuration and returns an error describing all
+// problems found. The firmware is only checked if requireFirmware is set.
+func (c *Config) Validate(requireFirmware bool) error {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if c.Interface == "" {
+		add("interface needs to be set")
+	} else if _, err := net.InterfaceByName(c.Interface); err != nil {
+		add("interface %q: %v", c.Interface, err)
+	}
+	if requireFirmware {
+		if c.Firmware == "" {
+			add("firmware needs to be set")
+		} else if _, err := os.Stat(c.Firmware); err != nil {
+			add("firmware: %v", err)
+		}
+	}
+	if _, err := c.firmwareRegions(); err != nil {
+		add("firmware_regions: %v", err)
+	}
+	if _, err := bootloader.ParseChecksum(c.DownloadChecksum); err != nil {
+		add("download_checksum: %v", err)
+	}
+	if c.MAC != "" {
+		if mac, err := net.ParseMAC(c.MAC); err != nil {
+			add("mac: %v", err)
+		} else if err := bootloader.CheckAddr(mac); err != nil {
+			add("mac: %v", err)
+		}
+	}
+	for q := range c.Challenges {
+		if _, err := parseHexUint32(q); err != nil {
+			add("challenges: question %q: %v", q, err)
+		}
+	}
+	for name, id := range c.IdentityProfiles {
+		if err := id.Validate(); err != nil {
+			add("identity_profiles: %v: %v", name, err)
+		}
+	}
+	if id, err := c.identity(); err != nil {
+		add("%v", err)
+	} else if err := id.Validate(); err != nil {
+		add("identity: %v", err)
+	}
+	if c.PollInterval <= 0 {
+		add("poll_interval needs to be positive")
+	}
+	if c.StatusInterval <= 0 {
+		add("status_interval needs to be positive")
+	}
+	if c.StallChecks < 1 {
+		add("stall_checks needs to be at least 1")
+	}
+	if m := c.Exporters.MQTT; m != nil {
+		if _, _, err := net.SplitHostPort(m.Broker); err != nil {
+			add("exporters: mqtt: broker: %v", err)
+		}
+		if strings.ContainsAny(m.TopicPrefix, "+#") || strings.ContainsAny(m.DiscoveryPrefix, "+#") {
+			add("exporters: mqtt: topic prefixes must not contain wildcards")
+		}
+	}
+	if c.HTTP.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.HTTP.Listen); err != nil {
+			add("http: listen: %v", err)
+		}
+	}
+	for _, step := range c.Recovery {
+		if _, ok := recoveryStepNames[step]; !ok {
+			add("recovery: unknown step %q", step)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// identity returns the selected identity profile merged with Identity.
+func (c *Config) identity() (ebm.Identity, error) {
+	var id ebm.Identity
+	if c.IdentityProfile != "" {
+		var ok bool
+		id, ok = c.IdentityProfiles[c.IdentityProfile]
+		if !ok {
+			return id, fmt.Errorf("identity_profile: unknown profile %q", c.IdentityProfile)
+		}
+	}
+	return id.Merge(c.Identity), nil
+}
+
+// challengeHandler answers with configured answers first and falls back to
+// the built-in ones.
+func (c *Config) challengeHandler() func(q uint32) uint32 {
+	answers := make(map[uint32]uint32)
+	for q, a := range c.Challenges {
+		qv, _ := parseHexUint32(q)
+		answers[qv] = uint32(a)
+	}
+	return func(q uint32) uint32 {
+		if a, ok := answers[q]; ok {
+			return a
+		}
+		return ebm.DefaultChallengeHandler(q)
+	}
+}
+
+// hexUint32 is a uint32 which can be given either as a JSON number or as a
+// string in any Go integer literal syntax, like "0xfe".
+type hexUint32 uint32
+
+func parseHexUint32(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 0, 32)
+	return uint32(v), err
+}
+
+func (h *hexUint32) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var v uint32
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("expected integer or string: %w", err)
+		}
+		*h = hexUint32(v)
+		return nil
+	}
+	v, err := parseHexUint32(s)
+	if err != nil {
+		return err
+	}
+	*h = hexUint32(v)
+	return nil
+}
+
+// duration is a time.Duration encoded as a string like "5s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("expected duration string: %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
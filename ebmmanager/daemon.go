@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/ctl"
+	"git.dolansoft.org/lorenz/metanoia-ebm/ebm"
+)
+
+// Event topics published by the daemon.
+var topics = []string{"status", "transition", "log", "console", "watchdog", "boot"}
+
+func daemonMain(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	attach := fs.Bool("attach", false, "Attach to an already running modem instead of booting it")
+	fs.Parse(args)
+	cfg := f.load(!*attach)
+	if cfg.Socket == "" {
+		log.Fatalln("socket needs to be set")
+	}
+	s := newSession(cfg)
+	srv := ctl.NewServer()
+	s.addEventHandler(srv.Publish)
+	s.registerMethods(srv)
+
+	// Only remove stale sockets, not anything else which happens to be there.
+	if fi, err := os.Lstat(cfg.Socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(cfg.Socket)
+	}
+	l, err := net.Listen("unix", cfg.Socket)
+	if err != nil {
+		log.Fatalf("failed to listen on control socket: %v", err)
+	}
+	if err := os.Chmod(cfg.Socket, 0o600); err != nil {
+		log.Fatalf("failed to set control socket permissions: %v", err)
+	}
+	go func() {
+		if err := srv.Serve(l); err != nil {
+			log.Fatalf("control socket failed: %v", err)
+		}
+	}()
+
+	pmEngine := startExporters(s)
+	if *attach {
+		err = s.findRunning()
+	} else {
+		_, err = s.ensureBooted()
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := s.connect(!*attach); err != nil {
+		log.Fatalln(err)
+	}
+	monitor(s, pmEngine, *f.json)
+}
+
+// registerMethods registers the control API methods of the session.
+func (s *session) registerMethods(srv *ctl.Server) {
+	withConn := func(h func(c *ebm.Conn, params json.RawMessage) (any, error)) ctl.Handler {
+		return func(params json.RawMessage) (any, error) {
+			c := s.Conn()
+			if c == nil {
+				return nil, errNotConnected
+			}
+			return h(c, params)
+		}
+	}
+	srv.Handle("get", withConn(func(c *ebm.Conn, params json.RawMessage) (any, error) {
+		var ref oidRef
+		if err := ctl.UnmarshalParams(params, &ref); err != nil {
+			return nil, err
+		}
+		return readOID(c, ref)
+	}))
+	srv.Handle("set", withConn(func(c *ebm.Conn, params json.RawMessage) (any, error) {
+		var p setParams
+		if err := ctl.UnmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		return true, writeOID(c, p)
+	}))
+	srv.Handle("status", withConn(func(c *ebm.Conn, _ json.RawMessage) (any, error) {
+		return c.LineStatus()
+	}))
+	srv.Handle("state", func(json.RawMessage) (any, error) {
+		return s.tracker.State(), nil
+	})
+	srv.Handle("console.write", withConn(func(c *ebm.Conn, params json.RawMessage) (any, error) {
+		var text string
+		if err := ctl.UnmarshalParams(params, &text); err != nil {
+			return nil, err
+		}
+		return true, c.WriteConsole([]byte(text))
+	}))
+	srv.Handle("reboot", func(json.RawMessage) (any, error) {
+		if s.cfg.Firmware == "" {
+			return nil, errors.New("rebooting requires the firmware to be configured")
+		}
+		return true, s.runStep("reboot")
+	})
+	srv.Handle("topics", func(json.RawMessage) (any, error) {
+		return topics, nil
+	})
+}
+
+func eventsMain(args []string) {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s events -socket <path> [topic...]\n\nTopics: %v\n", os.Args[0], topics)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	c := f.client()
+	if c == nil {
+		log.Fatalln("socket argument needs to be set")
+	}
+	defer c.Close()
+	subscribe := fs.Args()
+	if len(subscribe) == 0 {
+		subscribe = topics
+	}
+	if err := c.Subscribe(subscribe...); err != nil {
+		log.Fatalln(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for ev := range c.Events {
+		enc.Encode(ev)
+	}
+	log.Fatalln(ctl.ErrClientClosed)
+}
+
+func consoleMain(args []string) {
+	fs := flag.NewFlagSet("console", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	fs.Parse(args)
+	c := f.client()
+	if c == nil {
+		log.Fatalln("socket argument needs to be set")
+	}
+	defer c.Close()
+	if err := c.Subscribe("console"); err != nil {
+		log.Fatalln(err)
+	}
+	go func() {
+		for ev := range c.Events {
+			var text string
+			if err := json.Unmarshal(ev.Data, &text); err == nil {
+				os.Stdout.WriteString(text)
+			}
+		}
+		log.Fatalln(ctl.ErrClientClosed)
+	}()
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if err := c.Call("console.write", scanner.Text()+"\n", nil); err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+func rebootMain(args []string) {
+	fs := flag.NewFlagSet("reboot", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	fs.Parse(args)
+	c := f.client()
+	if c == nil {
+		log.Fatalln("socket argument needs to be set")
+	}
+	defer c.Close()
+	if err := c.Call("reboot", nil, nil); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/ebm"
+	"git.dolansoft.org/lorenz/metanoia-ebm/pm"
+)
+
+// maxRecentEvents is the number of events shown on the status page.
+const maxRecentEvents = 100
+
+// recentEvent is a session event kept for the status page.
+type recentEvent struct {
+	Time  time.Time `json:"time"`
+	Topic string    `json:"topic"`
+	Text  string    `json:"text"`
+}
+
+// eventLog keeps the most recent console, logger, transition and watchdog
+// events. It is safe for concurrent use.
+type eventLog struct {
+	mu     sync.Mutex
+	events []recentEvent
+	max    int
+}
+
+func newEventLog(max int) *eventLog {
+	return &eventLog{max: max}
+}
+
+func (l *eventLog) add(topic string, v any) {
+	var text string
+	switch x := v.(type) {
+	case string:
+		text = strings.TrimRight(x, "\r\n")
+	case *ebm.LogEvent:
+		text = x.String()
+	case ebm.Transition:
+		text = fmt.Sprintf("%v -> %v", x.From, x.To)
+		if x.Reason != "" {
+			text += " (" + x.Reason + ")"
+		}
+	case watchdogEvent:
+		text = x.Message
+	default:
+		// Status updates are not events
+		return
+	}
+	if text == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, recentEvent{Time: time.Now(), Topic: topic, Text: text})
+	if len(l.events) > l.max {
+		l.events = l.events[len(l.events)-l.max:]
+	}
+}
+
+// recent returns the kept events, newest first.
+func (l *eventLog) recent() []recentEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := make([]recentEvent, len(l.events))
+	for i, e := range l.events {
+		res[len(res)-1-i] = e
+	}
+	return res
+}
+
+// httpUI serves the status page and REST API.
+type httpUI struct {
+	s      *session
+	pm     *pm.Engine
+	events *eventLog
+}
+
+func (h *httpUI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h.index)
+	mux.HandleFunc("/api/status", h.get(func(*http.Request) (any, error) {
+		c := h.s.Conn()
+		if c == nil {
+			return nil, errNotConnected
+		}
+		return c.LineStatus()
+	}))
+	mux.HandleFunc("/api/state", h.get(func(*http.Request) (any, error) {
+		return h.s.tracker.State(), nil
+	}))
+	mux.HandleFunc("/api/pm", h.get(h.pmBins))
+	mux.HandleFunc("/api/snr", h.get(func(*http.Request) (any, error) {
+		return readSNRProfiles(h.s.Conn())
+	}))
+	mux.HandleFunc("/api/events", h.get(func(*http.Request) (any, error) {
+		return h.events.recent(), nil
+	}))
+	mux.HandleFunc("/api/snr.png", h.snrChart)
+	mux.HandleFunc("/api/retrain", h.post(func() error {
+		c := h.s.Conn()
+		if c == nil {
+			return errNotConnected
+		}
+		return c.Retrain()
+	}))
+	mux.HandleFunc("/api/reboot", h.post(func() error {
+		if h.s.cfg.Firmware == "" {
+			return fmt.Errorf("rebooting requires the firmware to be configured")
+		}
+		return h.s.runStep("reboot")
+	}))
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// get returns a handler for a read-only JSON endpoint.
+func (h *httpUI) get(f func(r *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+			return
+		}
+		v, err := f(r)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// post returns a handler for an authenticated action.
+func (h *httpUI) post(f func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+			return
+		}
+		token := h.s.cfg.HTTP.Token
+		if token == "" {
+			writeError(w, http.StatusForbidden, fmt.Errorf("no token configured, actions are disabled"))
+			return
+		}
+		scheme, auth, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if scheme != "Bearer" || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+		log.Printf("http: %v requested by %v", r.URL.Path, r.RemoteAddr)
+		if err := f(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			OK bool `json:"ok"`
+		}{true})
+	}
+}
+
+type pmResponse struct {
+	Current *pm.Bin   `json:"current"`
+	History []*pm.Bin `json:"history"`
+}
+
+func (h *httpUI) pmBins(r *http.Request) (any, error) {
+	if h.pm == nil {
+		return nil, fmt.Errorf("performance monitoring is disabled")
+	}
+	interval := pm.Interval(r.URL.Query().Get("interval"))
+	if interval == "" {
+		interval = pm.Interval15Min
+	}
+	if interval != pm.Interval15Min && interval != pm.Interval1Day {
+		return nil, fmt.Errorf("unknown interval %q", interval)
+	}
+	return &pmResponse{
+		Current: h.pm.Current(interval),
+		History: h.pm.Query(interval, time.Time{}, time.Time{}),
+	}, nil
+}
+
+func readSNRProfiles(c *ebm.Conn) ([]*ebm.SNRProfile, error) {
+	if c == nil {
+		return nil, errNotConnected
+	}
+	var profiles []*ebm.SNRProfile
+	for _, dir := range []ebm.Direction{ebm.Downstream, ebm.Upstream} {
+		p, err := c.ReadSNRProfile(dir)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+func (h *httpUI) snrChart(w http.ResponseWriter, r *http.Request) {
+	profiles, err := readSNRProfiles(h.s.Conn())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := ebm.WriteSNRChart(&buf, profiles...); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
+
+type pmCounter struct {
+	Name         string
+	Current, Day uint64
+}
+
+type indexData struct {
+	Status    *ebm.LineStatus
+	StatusErr error
+	State     *ebm.TrackerState
+	PM        []pmCounter
+	Events    []recentEvent
+}
+
+func (h *httpUI) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	d := indexData{
+		State:  h.s.tracker.State(),
+		Events: h.events.recent(),
+	}
+	if c := h.s.Conn(); c != nil {
+		d.Status, d.StatusErr = c.LineStatus()
+	} else {
+		d.StatusErr = errNotConnected
+	}
+	if h.pm != nil {
+		cur, day := h.pm.Current(pm.Interval15Min), h.pm.Current(pm.Interval1Day)
+		for _, counter := range pm.Counters {
+			c := pmCounter{Name: counter.Name}
+			if cur != nil {
+				c.Current = cur.Counts[counter.Name]
+			}
+			if day != nil {
+				c.Day = day.Counts[counter.Name]
+			}
+			d.PM = append(d.PM, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := indexTemplate.Execute(&buf, &d); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"since": func(t time.Time) time.Duration { return time.Since(t).Round(time.Second) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>ebmmanager</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+.error { color: #b00; }
+pre { font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>ebmmanager</h1>
+{{with .State}}<p>Modem status <b>{{.Current}}</b> for {{since .Since}}, {{.Retrains}} retrains, {{.FullInits}} full inits</p>{{end}}
+{{if .StatusErr}}<p class="error">Failed to read line status: {{.StatusErr}}</p>{{end}}
+{{with .Status}}
+<table>
+<tr><th></th><th>Downstream</th><th>Upstream</th></tr>
+<tr><td>Net data rate (kbit/s)</td><td>{{.Downstream.NetDataRate}}</td><td>{{.Upstream.NetDataRate}}</td></tr>
+<tr><td>Attainable rate (kbit/s)</td><td>{{.Downstream.AttainableNetDataRate}}</td><td>{{.Upstream.AttainableNetDataRate}}</td></tr>
+<tr><td>Expected throughput (kbit/s)</td><td>{{.Downstream.ExpectedThroughput}}</td><td>{{.Upstream.ExpectedThroughput}}</td></tr>
+<tr><td>SNR margin (dB)</td><td>{{.Downstream.SNRMargin}}</td><td>{{.Upstream.SNRMargin}}</td></tr>
+<tr><td>Transmit power (dBm)</td><td>{{.Downstream.Power}}</td><td>{{.Upstream.Power}}</td></tr>
+</table>
+<p>Central: {{if .CentralVendorError}}invalid ({{.CentralVendorError}}){{else}}{{.CentralVendor}}{{end}} {{.CentralVersion}}, uptime {{.Uptime}}</p>
+<img src="/api/snr.png" alt="SNR per subcarrier group" width="1024" height="400">
+{{end}}
+{{if .PM}}
+<h2>Error counters</h2>
+<table>
+<tr><th>Counter</th><th>Current 15 min</th><th>Current day</th></tr>
+{{range .PM}}<tr><td>{{.Name}}</td><td>{{.Current}}</td><td>{{.Day}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Recent events</h2>
+<pre>{{range .Events}}{{.Time.Format "2006-01-02 15:04:05"}} [{{.Topic}}] {{.Text}}
+{{end}}</pre>
+</body>
+</html>
+`))
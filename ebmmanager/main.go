@@ -1,91 +1,177 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"math/rand"
 	"net"
 	"os"
-	"time"
+	"sort"
 
 	"git.dolansoft.org/lorenz/metanoia-ebm/bootloader"
+	"git.dolansoft.org/lorenz/metanoia-ebm/ctl"
 	"git.dolansoft.org/lorenz/metanoia-ebm/ebm"
-	"github.com/mdlayher/packet"
 )
 
-var (
-	iface  = flag.String("if", "", "Network interface the modem is connected to")
-	fwPath = flag.String("fw", "", "Path to the firmware file in Motorola S-REC format")
-)
+type command struct {
+	usage string
+	run   func(args []string)
+}
 
-func main() {
-	flag.Parse()
-	if *iface == "" {
-		log.Fatalf("if argument needs to be set")
+var commands = map[string]command{
+	"boot":      {"Download the firmware and boot the modem", bootMain},
+	"attach":    {"Connect to an already running modem and monitor it", attachMain},
+	"get":       {"Read an OID by name or dotted number", getMain},
+	"set":       {"Write an OID by name or dotted number", setMain},
+	"status":    {"Print a summary of the line status", statusMain},
+	"identity":  {"Print or apply the NT identity of the modem", identityMain},
+	"probe":     {"Find out whether the modem is in bootloader or operational mode", probeMain},
+	"monitor":   {"Boot the modem, start the line and monitor it", monitorMain},
+	"pm":        {"Print the performance monitoring history", pmMain},
+	"daemon":    {"Own the modem session and serve the control socket", daemonMain},
+	"events":    {"Print events from the daemon", eventsMain},
+	"console":   {"Attach to the modem console through the daemon", consoleMain},
+	"reboot":    {"Reboot the modem through the daemon", rebootMain},
+	"verify-fw": {"Check the firmware image without touching the modem", verifyFirmwareMain},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	var names []string
+	for name := range commands {
+		names = append(names, name)
 	}
-	if *fwPath == "" {
-		log.Fatalf("fw argument needs to be set")
-	}
-	metanoiaIf, err := net.InterfaceByName(*iface)
-	if err != nil {
-		log.Fatalln(err)
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
+}
 
-	pktConn, err := packet.Listen(metanoiaIf, packet.Datagram, 0x6120, &packet.Config{})
-	if err != nil {
-		log.Fatalf("failed to create socket: %v", err)
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
 	}
-
-	deviceId := make([]byte, 3)
-	if _, err := rand.Read(deviceId); err != nil {
-		log.Fatalf("failed to get randomness: %v", err)
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
 	}
+	cmd.run(os.Args[2:])
+}
 
-	assignedAddr := net.HardwareAddr{0xde, 0x21, 0x65, deviceId[0], deviceId[1], deviceId[2]}
+// commonFlags are flags shared by all commands talking to the modem.
+type commonFlags struct {
+	config   *string
+	iface    *string
+	fw       *string
+	pmState  *string
+	checksum *string
+	keep     *bool
+	socket   *string
+	json     *bool
+}
 
-	fw, err := os.Open(*fwPath)
-	if err != nil {
-		log.Fatalf("failed to open firmware file: %v", err)
+func addCommonFlags(fs *flag.FlagSet) *commonFlags {
+	return &commonFlags{
+		config:   fs.String("config", "", "Path to the JSON configuration file"),
+		iface:    fs.String("if", "", "Network interface the modem is connected to (overrides config)"),
+		fw:       fs.String("fw", "", "Path to the firmware pack or Motorola S-REC file (overrides config)"),
+		pmState:  fs.String("pm-state", "", "Path to the file where performance monitoring history is kept (overrides config)"),
+		checksum: fs.String("checksum", "", "Firmware download checksum: firmware, known, stored, records or a fixed value (overrides config)"),
+		keep:     fs.Bool("keep-records", false, "Send every S-Record as its own download record instead of merging them (overrides config)"),
+		socket:   fs.String("socket", "", "Talk to the daemon listening on this control socket instead of the modem"),
+		json:     fs.Bool("json", false, "Output JSON for scripting"),
 	}
+}
 
-	err = bootloader.DownloadAndBoot(pktConn, assignedAddr, fw)
-	if err != nil {
-		log.Fatalf("failed to boot: %v", err)
+// load loads and validates the configuration, applying flag overrides.
+func (f *commonFlags) load(requireFirmware bool) *Config {
+	cfg := defaultConfig()
+	if *f.config != "" {
+		var err error
+		cfg, err = loadConfig(*f.config)
+		if err != nil {
+			log.Fatalln(err)
+		}
 	}
-
-	c := ebm.NewConn(pktConn, assignedAddr)
-	c.Logger = os.Stderr
-
-	if err := c.Dial(); err != nil {
-		log.Fatalf("failed to connect: %v", err)
+	if *f.iface != "" {
+		cfg.Interface = *f.iface
 	}
-
-	// Enable log and console output
-	if err := c.WriteMIB(&ebm.OidLogControl, uint32(0xfe)); err != nil {
-		log.Fatalf("failed to write log control: %v", err)
+	if *f.fw != "" {
+		cfg.Firmware = *f.fw
 	}
-	if err := c.WriteMIB(&ebm.OidConsoleControl, uint32(2)); err != nil {
-		log.Fatalf("failed to write console control: %v", err)
+	if *f.pmState != "" {
+		cfg.PMState = *f.pmState
 	}
-	// Enable Modem
-	if err := c.WriteMIB(&ebm.OidHostCommand, uint8(1)); err != nil {
-		log.Fatalf("failed to write host command: %v", err)
+	if *f.keep {
+		cfg.KeepRecords = true
 	}
-	if err := c.WriteMIB(&ebm.OidRepeatCommand, uint8(1)); err != nil {
-		log.Fatalf("failed to write repeat command: %v", err)
+	if *f.checksum != "" {
+		cfg.DownloadChecksum = *f.checksum
 	}
-	if err := c.WriteMIB(&ebm.OidCmdStatus, true); err != nil {
-		log.Fatalf("failed to write command status: %v", err)
+	if *f.socket != "" {
+		cfg.Socket = *f.socket
 	}
+	if err := cfg.Validate(requireFirmware); err != nil {
+		log.Fatalln(err)
+	}
+	return cfg
+}
 
-	for {
-		time.Sleep(5 * time.Second)
-		ticks, err := c.ReadMIB(&ebm.OidTicks)
-		if err != nil {
-			log.Fatalf("failed to read ticks: %v", err)
+// newSession creates a session for the configured modem.
+func newSession(cfg *Config) *session {
+	metanoiaIf, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var assignedAddr net.HardwareAddr
+	if cfg.MAC != "" {
+		assignedAddr, _ = net.ParseMAC(cfg.MAC)
+	} else {
+		if len(metanoiaIf.HardwareAddr) == 0 {
+			log.Fatalf("interface %v has no MAC to derive the modem MAC from, mac needs to be set", metanoiaIf.Name)
 		}
-		fmt.Printf("Ticks: %d\n", ticks.(uint32))
+		assignedAddr = bootloader.DeriveAddr(metanoiaIf.HardwareAddr, cfg.ModemID)
 	}
 
+	s := &session{
+		cfg:        cfg,
+		iface:      metanoiaIf,
+		assignAddr: assignedAddr,
+		addr:       assignedAddr,
+		tracker:    ebm.NewStateTracker(100),
+	}
+	s.tracker.OnTransition = func(t ebm.Transition) {
+		if t.Reason != "" {
+			log.Printf("Modem status %v -> %v (%v)", t.From, t.To, t.Reason)
+		} else {
+			log.Printf("Modem status %v -> %v", t.From, t.To)
+		}
+		s.publish("transition", t)
+	}
+	return s
+}
+
+// client connects to the daemon if -socket is set and returns nil otherwise.
+func (f *commonFlags) client() *ctl.Client {
+	if *f.socket == "" {
+		return nil
+	}
+	c, err := ctl.Dial(*f.socket)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return c
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalln(err)
+	}
 }
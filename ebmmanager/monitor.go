@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/pm"
+	"git.dolansoft.org/lorenz/metanoia-ebm/watchdog"
+)
+
+func monitorMain(args []string) {
+	fs := flag.NewFlagSet("monitor", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	fs.Parse(args)
+	cfg := f.load(true)
+	s := newSession(cfg)
+	if _, err := s.ensureBooted(); err != nil {
+		log.Fatalln(err)
+	}
+	pmEngine := startExporters(s)
+	if err := s.connect(true); err != nil {
+		log.Fatalln(err)
+	}
+	monitor(s, pmEngine, *f.json)
+}
+
+func attachMain(args []string) {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	f := addCommonFlags(fs)
+	fs.Parse(args)
+	cfg := f.load(false)
+	s := newSession(cfg)
+	if err := s.findRunning(); err != nil {
+		log.Fatalln(err)
+	}
+	pmEngine := startExporters(s)
+	if err := s.connect(false); err != nil {
+		log.Fatalln(err)
+	}
+	monitor(s, pmEngine, *f.json)
+}
+
+// startExporters opens the PM state and starts the HTTP server if they are
+// configured. It needs to be called before the session connects as it hooks
+// into the session events. The returned engine is nil if PM is disabled.
+func startExporters(s *session) *pm.Engine {
+	cfg := s.cfg
+	var pmEngine *pm.Engine
+	if cfg.PMState != "" {
+		var err error
+		pmEngine, err = pm.Open(cfg.PMState)
+		if err != nil {
+			log.Fatalf("failed to open PM state: %v", err)
+		}
+		pmEngine.MaxGap = 2 * time.Duration(cfg.StatusInterval)
+	}
+	if cfg.HTTP.Listen != "" {
+		h := &httpUI{s: s, pm: pmEngine, events: newEventLog(maxRecentEvents)}
+		s.addEventHandler(h.events.add)
+		go func() {
+			log.Fatalln(http.ListenAndServe(cfg.HTTP.Listen, h.handler()))
+		}()
+	}
+	if cfg.Exporters.MQTT != nil {
+		e := newMQTTExporter(cfg.Exporters.MQTT, s.assignAddr, pmEngine)
+		s.addEventHandler(e.handleEvent)
+		go e.run()
+		onShutdown(e.close)
+	}
+	return pmEngine
+}
+
+var (
+	shutdownMu       sync.Mutex
+	shutdownHandlers []func()
+)
+
+// onShutdown registers h to be run when ebmmanager is asked to terminate by
+// SIGINT or SIGTERM.
+func onShutdown(h func()) {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	if shutdownHandlers == nil {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			log.Printf("Received %v, shutting down", <-sig)
+			shutdownMu.Lock()
+			for _, h := range shutdownHandlers {
+				h()
+			}
+			os.Exit(0)
+		}()
+	}
+	shutdownHandlers = append(shutdownHandlers, h)
+}
+
+// monitor watches the modem connected to s forever. If jsonOut is set, a
+// line status JSON object is printed on each status poll.
+func monitor(s *session, pmEngine *pm.Engine, jsonOut bool) {
+	cfg := s.cfg
+
+	wd := watchdog.Watchdog{
+		ReadTicks: func() (uint32, error) {
+			ticks, err := s.readTicks()
+			if err == nil && cfg.Exporters.Log && !jsonOut {
+				fmt.Printf("Ticks: %d\n", ticks)
+			}
+			return ticks, err
+		},
+		Steps:       s.recoverySteps(cfg.Recovery),
+		Interval:    time.Duration(cfg.PollInterval),
+		StallChecks: cfg.StallChecks,
+		OnEvent: func(e watchdog.Event) {
+			log.Printf("watchdog: %v", e)
+			s.publish("watchdog", watchdogEvent{e.Time, e.Kind.String(), e.String()})
+		},
+		Logger: os.Stderr,
+	}
+	go wd.Run(nil)
+
+	enc := json.NewEncoder(os.Stdout)
+	for range time.Tick(time.Duration(cfg.StatusInterval)) {
+		c := s.Conn()
+		if c == nil {
+			continue
+		}
+		if err := s.tracker.Poll(c); err != nil {
+			log.Printf("failed to poll modem status: %v", err)
+		}
+		if pmEngine != nil {
+			if err := pmEngine.Sample(c); err != nil {
+				log.Printf("failed to sample PM counters: %v", err)
+			}
+		}
+		if jsonOut || s.events != nil {
+			status, err := c.LineStatus()
+			if err != nil {
+				log.Printf("failed to read line status: %v", err)
+				continue
+			}
+			s.publish("status", status)
+			if jsonOut {
+				enc.Encode(status)
+			}
+		}
+	}
+}
+
+// watchdogEvent is the JSON encoding of a watchdog.Event.
+type watchdogEvent struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+}
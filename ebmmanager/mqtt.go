@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/ebm"
+	"git.dolansoft.org/lorenz/metanoia-ebm/mqtt"
+	"git.dolansoft.org/lorenz/metanoia-ebm/pm"
+)
+
+// mqttReconnectDelay is the time between connection attempts to the broker.
+const mqttReconnectDelay = 10 * time.Second
+
+// mqttExporter publishes session events to an MQTT broker. The modem state
+// and showtime are retained, the line status and PM counters are published
+// on every status poll.
+type mqttExporter struct {
+	cfg    *MQTTConfig
+	node   string
+	prefix string
+	pm     *pm.Engine
+	events chan mqttEvent
+
+	client      *mqtt.Client
+	lastAttempt time.Time
+	// Retained values, republished after reconnecting
+	state    string
+	showtime string
+}
+
+type mqttEvent struct {
+	topic string
+	v     any
+}
+
+// newMQTTExporter returns an exporter for the modem with the configured or
+// derived MAC addr. It is not the MAC a running modem might have been found
+// with to keep topics and Home Assistant IDs stable.
+func newMQTTExporter(cfg *MQTTConfig, addr net.HardwareAddr, pmEngine *pm.Engine) *mqttExporter {
+	node := "ebm_" + hex.EncodeToString(addr)
+	prefix := cfg.TopicPrefix
+	if prefix == "" {
+		prefix = "ebmmanager/" + hex.EncodeToString(addr)
+	}
+	return &mqttExporter{
+		cfg:    cfg,
+		node:   node,
+		prefix: prefix,
+		pm:     pmEngine,
+		events: make(chan mqttEvent, 64),
+	}
+}
+
+// handleEvent queues a session event. It never blocks as it is called from
+// the modem receive loop, events are dropped if the broker is too slow.
+func (e *mqttExporter) handleEvent(topic string, v any) {
+	select {
+	case e.events <- mqttEvent{topic, v}:
+	default:
+	}
+}
+
+// close publishes the availability as offline and disconnects from the
+// broker, which does not publish the will for a clean disconnect.
+func (e *mqttExporter) close() {
+	done := make(chan struct{})
+	e.events <- mqttEvent{"close", done}
+	<-done
+}
+
+func (e *mqttExporter) run() {
+	for ev := range e.events {
+		switch ev.topic {
+		case "close":
+			if e.client != nil {
+				if err := e.client.Publish(mqtt.Message{Topic: e.topic("availability"), Payload: []byte("offline"), Retain: true}); err != nil {
+					log.Printf("mqtt: %v", err)
+				}
+				e.client.Close()
+				e.client = nil
+			}
+			close(ev.v.(chan struct{}))
+			return
+		case "transition":
+			t := ev.v.(ebm.Transition)
+			e.setState(t.To)
+		case "status":
+			status := ev.v.(*ebm.LineStatus)
+			e.setState(status.ModemStatus)
+			e.publishJSON("status", status, false)
+			if e.pm != nil {
+				counts := make(map[pm.Interval]map[string]uint64)
+				for _, i := range pm.Intervals {
+					if b := e.pm.Current(i); b != nil {
+						counts[i] = b.Counts
+					}
+				}
+				e.publishJSON("pm", counts, false)
+			}
+		}
+	}
+}
+
+// setState publishes the retained modem state and showtime if they changed.
+func (e *mqttExporter) setState(s ebm.ModemStatus) {
+	state, showtime := s.String(), "OFF"
+	if s.IsShowtime() {
+		showtime = "ON"
+	}
+	if state != e.state {
+		e.state = state
+		e.publish("state", []byte(state), true)
+	}
+	if showtime != e.showtime {
+		e.showtime = showtime
+		e.publish("showtime", []byte(showtime), true)
+	}
+}
+
+func (e *mqttExporter) topic(name string) string {
+	return e.prefix + "/" + name
+}
+
+func (e *mqttExporter) publishJSON(name string, v any, retain bool) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("mqtt: failed to encode %v: %v", name, err)
+		return
+	}
+	e.publish(name, payload, retain)
+}
+
+func (e *mqttExporter) publish(name string, payload []byte, retain bool) {
+	c := e.connect()
+	if c == nil {
+		return
+	}
+	if err := c.Publish(mqtt.Message{Topic: e.topic(name), Payload: payload, Retain: retain}); err != nil {
+		log.Printf("mqtt: %v", err)
+	}
+}
+
+// connect returns a connected client, reconnecting if necessary. It returns
+// nil if the broker is not reachable.
+func (e *mqttExporter) connect() *mqtt.Client {
+	if e.client != nil {
+		select {
+		case <-e.client.Done():
+			log.Printf("mqtt: connection lost: %v", e.client.Err())
+			e.client = nil
+		default:
+			return e.client
+		}
+	}
+	if time.Since(e.lastAttempt) < mqttReconnectDelay {
+		return nil
+	}
+	e.lastAttempt = time.Now()
+	c, err := mqtt.Dial(mqtt.Options{
+		Addr:     e.cfg.Broker,
+		ClientID: e.cfg.ClientID,
+		Username: e.cfg.Username,
+		Password: e.cfg.Password,
+		Will:     &mqtt.Message{Topic: e.topic("availability"), Payload: []byte("offline"), Retain: true},
+	})
+	if err != nil {
+		log.Printf("mqtt: %v", err)
+		return nil
+	}
+	msgs := []mqtt.Message{{Topic: e.topic("availability"), Payload: []byte("online"), Retain: true}}
+	if e.state != "" {
+		msgs = append(msgs,
+			mqtt.Message{Topic: e.topic("state"), Payload: []byte(e.state), Retain: true},
+			mqtt.Message{Topic: e.topic("showtime"), Payload: []byte(e.showtime), Retain: true},
+		)
+	}
+	if e.cfg.DiscoveryPrefix != "" {
+		msgs = append(msgs, e.discoveryMessages()...)
+	}
+	for _, m := range msgs {
+		if err := c.Publish(m); err != nil {
+			log.Printf("mqtt: %v", err)
+			c.Close()
+			return nil
+		}
+	}
+	e.client = c
+	return c
+}
+
+// haEntity is a Home Assistant MQTT discovery config.
+type haEntity struct {
+	Name              string    `json:"name"`
+	UniqueID          string    `json:"unique_id"`
+	ObjectID          string    `json:"object_id"`
+	StateTopic        string    `json:"state_topic"`
+	ValueTemplate     string    `json:"value_template,omitempty"`
+	Unit              string    `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string    `json:"device_class,omitempty"`
+	StateClass        string    `json:"state_class,omitempty"`
+	EntityCategory    string    `json:"entity_category,omitempty"`
+	PayloadOn         string    `json:"payload_on,omitempty"`
+	PayloadOff        string    `json:"payload_off,omitempty"`
+	AvailabilityTopic string    `json:"availability_topic"`
+	Device            *haDevice `json:"device"`
+}
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// discoveryMessages returns retained Home Assistant discovery configs for
+// all published values.
+func (e *mqttExporter) discoveryMessages() []mqtt.Message {
+	device := &haDevice{
+		Identifiers:  []string{e.node},
+		Name:         "G.fast modem " + e.node,
+		Manufacturer: "Metanoia",
+		Model:        "MT-G5321",
+	}
+	var msgs []mqtt.Message
+	add := func(component, object string, ent haEntity) {
+		ent.UniqueID = e.node + "_" + object
+		ent.ObjectID = ent.UniqueID
+		ent.AvailabilityTopic = e.topic("availability")
+		ent.Device = device
+		payload, _ := json.Marshal(&ent)
+		msgs = append(msgs, mqtt.Message{
+			Topic:   fmt.Sprintf("%s/%s/%s/%s/config", e.cfg.DiscoveryPrefix, component, e.node, object),
+			Payload: payload,
+			Retain:  true,
+		})
+	}
+	add("sensor", "modem_status", haEntity{Name: "Modem status", StateTopic: e.topic("state")})
+	add("binary_sensor", "showtime", haEntity{
+		Name:        "Showtime",
+		StateTopic:  e.topic("showtime"),
+		DeviceClass: "connectivity",
+		PayloadOn:   "ON",
+		PayloadOff:  "OFF",
+	})
+	status := func(object, name, field, unit, deviceClass string) {
+		add("sensor", object, haEntity{
+			Name:          name,
+			StateTopic:    e.topic("status"),
+			ValueTemplate: "{{ value_json." + field + " }}",
+			Unit:          unit,
+			DeviceClass:   deviceClass,
+			StateClass:    "measurement",
+		})
+	}
+	for _, d := range []struct{ dir, prefix, title string }{
+		{"downstream", "ds_", "Downstream"},
+		{"upstream", "us_", "Upstream"},
+	} {
+		dir, prefix, title := d.dir, d.prefix, d.title
+		status(prefix+"net_data_rate", title+" net data rate", dir+".net_data_rate", "kbit/s", "data_rate")
+		status(prefix+"attainable_net_data_rate", title+" attainable net data rate", dir+".attainable_net_data_rate", "kbit/s", "data_rate")
+		status(prefix+"expected_throughput", title+" expected throughput", dir+".expected_throughput", "kbit/s", "data_rate")
+		status(prefix+"snr_margin", title+" SNR margin", dir+".snr_margin_db", "dB", "")
+		status(prefix+"power", title+" transmit power", dir+".power_dbm", "dBm", "")
+	}
+	add("sensor", "uptime", haEntity{
+		Name:           "Uptime",
+		StateTopic:     e.topic("status"),
+		ValueTemplate:  "{{ (value_json.uptime / 1000000000) | int }}",
+		Unit:           "s",
+		DeviceClass:    "duration",
+		EntityCategory: "diagnostic",
+	})
+	if e.pm != nil {
+		for _, c := range pm.Counters {
+			add("sensor", "pm_1d_"+c.Name, haEntity{
+				Name:           "Today " + c.Name,
+				StateTopic:     e.topic("pm"),
+				ValueTemplate:  fmt.Sprintf("{{ value_json['%s'].%s | default(0) }}", pm.Interval1Day, c.Name),
+				StateClass:     "total_increasing",
+				EntityCategory: "diagnostic",
+			})
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/pm"
+)
+
+// pmMain implements the pm command which prints the performance monitoring
+// history kept by a running ebmmanager.
+func pmMain(args []string) {
+	fs := flag.NewFlagSet("pm", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to the JSON configuration file")
+	statePath := fs.String("pm-state", "", "Path to the performance monitoring state file (overrides config)")
+	interval := fs.String("interval", string(pm.Interval15Min), "Bin interval to show (15m or 1d)")
+	since := fs.Duration("since", 0, "Only show bins from this long ago (0 shows everything)")
+	jsonOut := fs.Bool("json", false, "Output bins as JSON")
+	fs.Parse(args)
+	if *statePath == "" && *configPath != "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		*statePath = cfg.PMState
+	}
+	if *statePath == "" {
+		log.Fatalln("pm-state argument or pm_state in the config needs to be set")
+	}
+	i := pm.Interval(*interval)
+	if i != pm.Interval15Min && i != pm.Interval1Day {
+		log.Fatalf("unknown interval %q", *interval)
+	}
+	e, err := pm.Open(*statePath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var from time.Time
+	if *since > 0 {
+		from = time.Now().Add(-*since)
+	}
+	var bins []*pm.Bin
+	if cur := e.Current(i); cur != nil {
+		bins = append(bins, cur)
+	}
+	bins = append(bins, e.Query(i, from, time.Time{})...)
+
+	if *jsonOut {
+		printJSON(bins)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight)
+	header := []string{"start", "suspect"}
+	for _, c := range pm.Counters {
+		header = append(header, c.Name)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+	for _, b := range bins {
+		row := []string{b.Start.Local().Format("2006-01-02 15:04"), fmt.Sprint(b.Suspect)}
+		for _, c := range pm.Counters {
+			row = append(row, fmt.Sprint(b.Counts[c.Name]))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+	}
+	tw.Flush()
+}
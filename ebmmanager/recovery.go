@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/watchdog"
+)
+
+// rebootDelay is the time the modem needs after a reboot request to come
+// back up in bootloader mode.
+const rebootDelay = 5 * time.Second
+
+var recoveryStepNames = map[string]func(s *session) error{
+	// Reconnecting does not touch the line, the session might just have
+	// timed out.
+	"reconnect": func(s *session) error {
+		return s.connect(false)
+	},
+	// Rebooting resets the modem, which comes back up either running the
+	// firmware from its flash or in bootloader mode. The firmware is only
+	// downloaded in the latter case.
+	"reboot": func(s *session) error {
+		c := s.Conn()
+		if c == nil {
+			return errNotConnected
+		}
+		if err := c.Reboot(); err != nil {
+			return err
+		}
+		s.closeConn()
+		time.Sleep(rebootDelay)
+		if _, err := s.ensureBooted(); err != nil {
+			return err
+		}
+		return s.connect(true)
+	},
+	// Redownloading does not need a working connection, it expects the
+	// modem to be in bootloader mode.
+	"redownload": func(s *session) error {
+		if err := s.boot(); err != nil {
+			return err
+		}
+		return s.connect(true)
+	},
+}
+
+// recoverySteps returns the watchdog recovery steps for the names in ladder.
+// The names need to be validated beforehand. Without a firmware configured,
+// like when attaching, redownload can only fail and is left out.
+func (s *session) recoverySteps(ladder []string) []watchdog.Step {
+	var steps []watchdog.Step
+	for _, name := range ladder {
+		name := name
+		if name == "redownload" && s.cfg.Firmware == "" {
+			log.Printf("No firmware configured, leaving out recovery step %v", name)
+			continue
+		}
+		steps = append(steps, watchdog.Step{Name: name, Run: func(reason string) error {
+			return s.runStep(name)
+		}})
+	}
+	return steps
+}
+
+// runStep runs the named recovery step. Only one step runs at a time.
+func (s *session) runStep(name string) error {
+	s.recoverMu.Lock()
+	defer s.recoverMu.Unlock()
+	return recoveryStepNames[name](s)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/bootloader"
+	"git.dolansoft.org/lorenz/metanoia-ebm/ebm"
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+	"github.com/mdlayher/packet"
+)
+
+// session owns the connection to the modem and can re-establish it from
+// scratch, which is used for recovering a stuck modem.
+type session struct {
+	cfg   *Config
+	iface *net.Interface
+	// assignAddr is the MAC assigned to the modem when booting it, addr the
+	// one it currently uses. They only differ if the modem was found
+	// running with another MAC.
+	assignAddr net.HardwareAddr
+	addr       net.HardwareAddr
+	tracker    *ebm.StateTracker
+	// lastBoot describes the last firmware download.
+	lastBoot *bootloader.Summary
+	// events receives session events by topic if set, see publish.
+	events func(topic string, v any)
+
+	recoverMu sync.Mutex
+	mu        sync.Mutex
+	conn      *ebm.Conn
+}
+
+// addEventHandler adds h to the handlers of session events. It must be
+// called before the session connects.
+func (s *session) addEventHandler(h func(topic string, v any)) {
+	next := s.events
+	s.events = func(topic string, v any) {
+		h(topic, v)
+		if next != nil {
+			next(topic, v)
+		}
+	}
+}
+
+// publish sends an event to the events callback if one is set.
+func (s *session) publish(topic string, v any) {
+	if s.events != nil {
+		s.events(topic, v)
+	}
+}
+
+// boot downloads the firmware to a modem in bootloader mode and boots it.
+func (s *session) boot() error {
+	s.closeConn()
+	pc, err := packet.Listen(s.iface, packet.Datagram, 0x6120, &packet.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to create socket: %w", err)
+	}
+	defer pc.Close()
+	return s.download(pc, nil)
+}
+
+// download downloads the firmware on pc, continuing bs if it is not nil.
+func (s *session) download(pc net.PacketConn, bs *bootloader.Session) error {
+	fw, err := os.Open(s.cfg.Firmware)
+	if err != nil {
+		return fmt.Errorf("failed to open firmware file: %w", err)
+	}
+	defer fw.Close()
+	regions, _ := s.cfg.firmwareRegions()
+	checksum, _ := bootloader.ParseChecksum(s.cfg.DownloadChecksum)
+	opts := &bootloader.Options{Progress: progressPrinter(os.Stderr), Regions: regions, Checksum: checksum, KeepRecords: s.cfg.KeepRecords, Session: bs}
+	var summary *bootloader.Summary
+	if fwpack.IsPack(fw) {
+		summary, err = bootloader.DownloadPackAndBoot(pc, s.assignAddr, fw, opts)
+	} else {
+		summary, err = bootloader.DownloadAndBoot(pc, s.assignAddr, fw, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to boot: %w", err)
+	}
+	log.Printf("Firmware downloaded: %d records, %d bytes, %d retries in %v (associate %v, download %v, end %v)",
+		summary.Records, summary.Bytes, summary.Retries, summary.Total.Round(time.Millisecond),
+		summary.Associate.Round(time.Millisecond), summary.Download.Round(time.Millisecond), summary.End.Round(time.Millisecond))
+	s.lastBoot = summary
+	s.publish("boot", summary)
+	s.addr = s.assignAddr
+	return nil
+}
+
+// progressPrinter returns a progress callback rendering a single, updating
+// progress line to w if it is a terminal. It returns nil otherwise, the
+// summary is logged after the download anyway.
+func progressPrinter(w *os.File) func(bootloader.Progress) {
+	if fi, err := w.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return func(p bootloader.Progress) {
+		eta := "?"
+		if p.Bytes > 0 {
+			eta = p.ETA.Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "\rDownloading firmware: %d/%d records, %d/%d KiB, %d retries, %v elapsed, ETA %v\x1b[K",
+			p.Records, p.TotalRecords, p.Bytes/1024, p.TotalBytes/1024, p.Retries, p.Elapsed.Round(time.Second), eta)
+		if p.Records == p.TotalRecords {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// probe finds out in which mode the modem is. A modem in bootloader mode is
+// associated with the default address and left waiting for a download.
+func (s *session) probe() (*bootloader.ProbeResult, error) {
+	pc, err := packet.Listen(s.iface, packet.Datagram, 0x6120, &packet.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket: %w", err)
+	}
+	defer pc.Close()
+	return bootloader.Probe(pc, bootloader.DefaultProbeTimeout, nil)
+}
+
+// ensureBooted boots the modem if it is in bootloader mode. If it already
+// runs a firmware, its MAC is used instead. It returns true if the firmware
+// was downloaded. The download continues the session of the probe to not
+// associate the modem twice.
+func (s *session) ensureBooted() (bool, error) {
+	s.closeConn()
+	pc, err := packet.Listen(s.iface, packet.Datagram, 0x6120, &packet.Config{})
+	if err != nil {
+		return false, fmt.Errorf("failed to create socket: %w", err)
+	}
+	defer pc.Close()
+	res, err := bootloader.Probe(pc, bootloader.DefaultProbeTimeout, s.assignAddr)
+	if err != nil {
+		return false, err
+	}
+	switch res.Mode {
+	case bootloader.ModeOperational:
+		s.useRunning(res.Addr)
+		return false, nil
+	case bootloader.ModeBootloader:
+		return true, s.download(pc, res.Session)
+	default:
+		return false, fmt.Errorf("no modem found on %v", s.iface.Name)
+	}
+}
+
+// findRunning probes for a modem which already runs a firmware and uses its
+// MAC.
+func (s *session) findRunning() error {
+	res, err := s.probe()
+	if err != nil {
+		return err
+	}
+	switch res.Mode {
+	case bootloader.ModeOperational:
+		s.useRunning(res.Addr)
+		return nil
+	case bootloader.ModeBootloader:
+		return fmt.Errorf("modem on %v is in bootloader mode and needs a firmware download", s.iface.Name)
+	default:
+		return fmt.Errorf("no modem found on %v", s.iface.Name)
+	}
+}
+
+func (s *session) useRunning(addr net.HardwareAddr) {
+	if !bytes.Equal(addr, s.assignAddr) {
+		log.Printf("Modem already running with MAC %v instead of %v", addr, s.assignAddr)
+	}
+	s.addr = addr
+}
+
+// connect establishes a new EBM connection to the modem, replacing the
+// existing one. If startLine is set, the line is configured and started,
+// otherwise only log and console output are enabled.
+func (s *session) connect(startLine bool) error {
+	s.closeConn()
+	c, err := ebm.NewConnFromIf(s.iface, s.addr)
+	if err != nil {
+		return err
+	}
+	c.Logger = os.Stderr
+	c.HandleChallenge = s.cfg.challengeHandler()
+	c.HandleLogEvent = func(e *ebm.LogEvent) {
+		s.tracker.HandleLogEvent(e)
+		s.publish("log", e)
+	}
+	c.HandleConsoleOutput = func(text []byte) {
+		os.Stderr.Write(text)
+		s.publish("console", string(text))
+	}
+	if err := c.Dial(); err != nil {
+		c.Close()
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	if err := s.enableOutput(c); err != nil {
+		c.Close()
+		return err
+	}
+	if startLine {
+		if err := s.setupLine(c); err != nil {
+			c.Close()
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.conn = c
+	s.mu.Unlock()
+	return nil
+}
+
+// enableOutput enables log and console output.
+func (s *session) enableOutput(c *ebm.Conn) error {
+	if err := c.WriteMIB(&ebm.OidLogControl, uint32(s.cfg.LogMask)); err != nil {
+		return fmt.Errorf("failed to write log control: %w", err)
+	}
+	if err := c.WriteMIB(&ebm.OidConsoleControl, uint32(s.cfg.ConsoleLevel)); err != nil {
+		return fmt.Errorf("failed to write console control: %w", err)
+	}
+	return nil
+}
+
+// setupLine applies the configuration to the modem and starts the line.
+func (s *session) setupLine(c *ebm.Conn) error {
+	if id, _ := s.cfg.identity(); !id.IsZero() {
+		if err := c.WriteIdentity(&id); err != nil {
+			return fmt.Errorf("failed to apply identity: %w", err)
+		}
+	}
+	if rate := s.cfg.RateCaps.Downstream; rate != 0 {
+		if err := c.WriteMIB(&ebm.OidMaxNetDataRateDownstream, rate); err != nil {
+			return fmt.Errorf("failed to write downstream rate cap: %w", err)
+		}
+	}
+	if rate := s.cfg.RateCaps.Upstream; rate != 0 {
+		if err := c.WriteMIB(&ebm.OidMaxNetDataRateUpstream, rate); err != nil {
+			return fmt.Errorf("failed to write upstream rate cap: %w", err)
+		}
+	}
+	// Enable Modem. It is not verified that the modem acknowledges host
+	// commands, so a timeout is only a warning.
+	err := c.RunHostCommand(ebm.HostCommandStart)
+	if errors.Is(err, ebm.ErrHostCommandTimeout) {
+		log.Printf("WARNING: %v, continuing", err)
+		return nil
+	}
+	return err
+}
+
+func (s *session) closeConn() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+var errNotConnected = errors.New("not connected to modem")
+
+// dial establishes a plain EBM connection for one-shot commands without
+// touching the modem configuration. The modem is probed first to use the MAC
+// it is actually running with. The session does not track the connection.
+func (s *session) dial() (*ebm.Conn, error) {
+	if err := s.findRunning(); err != nil {
+		return nil, err
+	}
+	c, err := ebm.NewConnFromIf(s.iface, s.addr)
+	if err != nil {
+		return nil, err
+	}
+	c.Logger = os.Stderr
+	c.HandleChallenge = s.cfg.challengeHandler()
+	if err := c.Dial(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	return c, nil
+}
+
+// Conn returns the current connection or nil if there is none.
+func (s *session) Conn() *ebm.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+// readTicks reads OidTicks on the current connection.
+func (s *session) readTicks() (uint32, error) {
+	c := s.Conn()
+	if c == nil {
+		return 0, errNotConnected
+	}
+	ticks, err := c.ReadMIB(&ebm.OidTicks)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := ticks.(uint32)
+	if !ok {
+		return 0, fmt.Errorf("ticks have unexpected type %T", ticks)
+	}
+	return v, nil
+}
@@ -0,0 +1,174 @@
+// Package fwexport writes deobfuscated firmware in formats understood by
+// disassemblers and other tools.
+package fwexport
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+)
+
+// ihexData is the number of data bytes per Intel HEX record.
+const ihexData = 16
+
+// WriteIntelHex writes segs as Intel HEX file with 32 bit addresses.
+func WriteIntelHex(w io.Writer, segs []fwpack.Segment) error {
+	bw := bufio.NewWriter(w)
+	record := func(typ byte, addr uint16, data []byte) {
+		rec := []byte{byte(len(data)), byte(addr >> 8), byte(addr), typ}
+		rec = append(rec, data...)
+		var sum byte
+		for _, b := range rec {
+			sum += b
+		}
+		rec = append(rec, -sum)
+		fmt.Fprintf(bw, ":%X\n", rec)
+	}
+	upper := -1
+	for _, s := range segs {
+		if s.End() > 1<<32 {
+			return fmt.Errorf("segment at %08x exceeds the address space", s.Addr)
+		}
+		for off := 0; off < len(s.Data); {
+			addr := s.Addr + uint32(off)
+			if int(addr>>16) != upper {
+				upper = int(addr >> 16)
+				record(0x04, 0, []byte{byte(upper >> 8), byte(upper)})
+			}
+			// Records must not cross a 64 KiB boundary
+			n := ihexData
+			if rest := 0x10000 - int(addr&0xffff); rest < n {
+				n = rest
+			}
+			if rest := len(s.Data) - off; rest < n {
+				n = rest
+			}
+			record(0x00, uint16(addr), s.Data[off:off+n])
+			off += n
+		}
+	}
+	record(0x01, 0, nil)
+	return bw.Flush()
+}
+
+// WriteBinary writes segs as flat binary starting at base. Gaps between
+// segments are filled with fill. All segments need to be at or above base.
+func WriteBinary(w io.Writer, segs []fwpack.Segment, base uint32, fill byte) error {
+	sorted := make([]fwpack.Segment, len(segs))
+	copy(sorted, segs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Addr < sorted[j].Addr })
+	bw := bufio.NewWriter(w)
+	pos := uint64(base)
+	for _, s := range sorted {
+		if uint64(s.Addr) < pos {
+			if uint64(s.Addr) < uint64(base) {
+				return fmt.Errorf("segment at %08x is below the base address %08x", s.Addr, base)
+			}
+			return fmt.Errorf("segment at %08x overlaps the previous one", s.Addr)
+		}
+		for ; pos < uint64(s.Addr); pos++ {
+			bw.WriteByte(fill)
+		}
+		bw.Write(s.Data)
+		pos = s.End()
+	}
+	return bw.Flush()
+}
+
+// WriteELF writes segs as big-endian ELF32 Xtensa executable with one
+// PT_LOAD segment and one section per segment, which is what Ghidra and IDA
+// load best.
+func WriteELF(w io.Writer, segs []fwpack.Segment, entry uint32) error {
+	const (
+		ehsize    = 52
+		phentsize = 32
+		shentsize = 40
+	)
+	bo := binary.BigEndian
+	shstrtab := []byte{0}
+	addName := func(name string) uint32 {
+		off := uint32(len(shstrtab))
+		shstrtab = append(append(shstrtab, name...), 0)
+		return off
+	}
+
+	dataOff := uint32(ehsize + phentsize*len(segs))
+	var progs []elf.Prog32
+	sections := []elf.Section32{{}}
+	off := dataOff
+	for i, s := range segs {
+		if s.End() > 1<<32 {
+			return fmt.Errorf("segment at %08x exceeds the address space", s.Addr)
+		}
+		size := uint32(len(s.Data))
+		progs = append(progs, elf.Prog32{
+			Type:   uint32(elf.PT_LOAD),
+			Off:    off,
+			Vaddr:  s.Addr,
+			Paddr:  s.Addr,
+			Filesz: size,
+			Memsz:  size,
+			Flags:  uint32(elf.PF_R | elf.PF_W | elf.PF_X),
+			Align:  1,
+		})
+		sections = append(sections, elf.Section32{
+			Name:      addName(fmt.Sprintf(".load%d", i)),
+			Type:      uint32(elf.SHT_PROGBITS),
+			Flags:     uint32(elf.SHF_ALLOC | elf.SHF_WRITE | elf.SHF_EXECINSTR),
+			Addr:      s.Addr,
+			Off:       off,
+			Size:      size,
+			Addralign: 1,
+		})
+		off += size
+	}
+	shstrtabName := addName(".shstrtab")
+	sections = append(sections, elf.Section32{
+		Name:      shstrtabName,
+		Type:      uint32(elf.SHT_STRTAB),
+		Off:       off,
+		Size:      uint32(len(shstrtab)),
+		Addralign: 1,
+	})
+	off += uint32(len(shstrtab))
+	// Section headers are aligned to 4 bytes
+	pad := (4 - off%4) % 4
+	shoff := off + pad
+
+	hdr := elf.Header32{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_XTENSA),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     entry,
+		Phoff:     ehsize,
+		Shoff:     shoff,
+		Ehsize:    ehsize,
+		Phentsize: phentsize,
+		Phnum:     uint16(len(progs)),
+		Shentsize: shentsize,
+		Shnum:     uint16(len(sections)),
+		Shstrndx:  uint16(len(sections) - 1),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2MSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, bo, &hdr)
+	binary.Write(&buf, bo, progs)
+	for _, s := range segs {
+		buf.Write(s.Data)
+	}
+	buf.Write(shstrtab)
+	buf.Write(make([]byte, pad))
+	binary.Write(&buf, bo, sections)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
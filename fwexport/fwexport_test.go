@@ -0,0 +1,80 @@
+package fwexport
+
+import (
+	"bytes"
+	"debug/elf"
+	"strings"
+	"testing"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+)
+
+var testSegments = []fwpack.Segment{
+	{Addr: 0x0000fffc, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+	{Addr: 0x60000000, Data: []byte{9, 10, 11, 12}},
+}
+
+func TestWriteIntelHex(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteIntelHex(&buf, testSegments); err != nil {
+		t.Fatal(err)
+	}
+	// The first segment crosses a 64 KiB boundary
+	expected := strings.Join([]string{
+		":020000040000FA",
+		":04FFFC0001020304F7",
+		":020000040001F9",
+		":0400000005060708E2",
+		":0200000460009A",
+		":04000000090A0B0CD2",
+		":00000001FF",
+		"",
+	}, "\n")
+	if buf.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", buf.String(), expected)
+	}
+}
+
+func TestWriteBinary(t *testing.T) {
+	var buf bytes.Buffer
+	segs := []fwpack.Segment{{Addr: 0x1008, Data: []byte{3}}, {Addr: 0x1002, Data: []byte{1, 2}}}
+	if err := WriteBinary(&buf, segs, 0x1000, 0xff); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0xff, 0xff, 1, 2, 0xff, 0xff, 0xff, 0xff, 3}; !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("got %x, expected %x", buf.Bytes(), expected)
+	}
+	if err := WriteBinary(&buf, segs, 0x1004, 0); err == nil {
+		t.Error("segment below base accepted")
+	}
+}
+
+func TestWriteELF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteELF(&buf, testSegments, 0x60000000); err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Class != elf.ELFCLASS32 || f.Data != elf.ELFDATA2MSB || f.Machine != elf.EM_XTENSA || f.Type != elf.ET_EXEC || f.Entry != 0x60000000 {
+		t.Errorf("unexpected header %+v", f.FileHeader)
+	}
+	if len(f.Progs) != len(testSegments) {
+		t.Fatalf("expected %d program headers, got %d", len(testSegments), len(f.Progs))
+	}
+	for i, p := range f.Progs {
+		data := make([]byte, p.Filesz)
+		if _, err := p.ReadAt(data, 0); err != nil {
+			t.Fatal(err)
+		}
+		if p.Type != elf.PT_LOAD || p.Vaddr != uint64(testSegments[i].Addr) || !bytes.Equal(data, testSegments[i].Data) {
+			t.Errorf("program header %d: %+v with data %x", i, p.ProgHeader, data)
+		}
+	}
+	s := f.Section(".load1")
+	if s == nil || s.Addr != 0x60000000 {
+		t.Errorf("unexpected section .load1: %+v", s)
+	}
+}
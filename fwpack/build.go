@@ -0,0 +1,125 @@
+package fwpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// packVersion is the version written by Build.
+const packVersion = 0x20000
+
+// MaxRecordData is the largest data length of a record, its length in words
+// is stored in a single byte.
+const MaxRecordData = 255 * 4
+
+// BuildImage is an image to be put into a pack by Build.
+type BuildImage struct {
+	Signature uint32
+	// Records are deobfuscated, Raw is not used. Data needs to be a
+	// multiple of 4 bytes long, records longer than MaxRecordData are split.
+	Records []Record
+}
+
+// Build creates a pack containing images. The records are obfuscated and
+// terminated. As it is not known what the checksum of real images is
+// calculated over, the one of each image is set to Image.RecordsChecksum,
+// which is unverified.
+func Build(images []BuildImage) ([]byte, error) {
+	if len(images) >= HeaderSize/EntrySize {
+		return nil, fmt.Errorf("at most %d images fit into a pack", HeaderSize/EntrySize-1)
+	}
+	pack := make([]byte, HeaderSize)
+	binary.BigEndian.PutUint32(pack[0:4], Signature)
+	binary.BigEndian.PutUint32(pack[4:8], uint32(len(images)))
+	binary.BigEndian.PutUint32(pack[16:20], packVersion)
+	for i, img := range images {
+		data, records, err := buildImage(img)
+		if err != nil {
+			return nil, fmt.Errorf("image %d (%08x): %w", i+1, img.Signature, err)
+		}
+		entry := pack[(i+1)*EntrySize : (i+2)*EntrySize]
+		binary.BigEndian.PutUint32(entry[0:4], img.Signature)
+		binary.BigEndian.PutUint32(entry[4:8], uint32(len(data)))
+		binary.BigEndian.PutUint32(entry[16:20], uint32(len(pack)))
+		binary.BigEndian.PutUint32(entry[24:28], uint32(records))
+		pack = append(pack, data...)
+	}
+	return pack, nil
+}
+
+// buildImage returns the image including its header and the number of
+// records in it.
+func buildImage(img BuildImage) ([]byte, int, error) {
+	var records []Record
+	for _, r := range img.Records {
+		if len(r.Data)%4 != 0 {
+			return nil, 0, fmt.Errorf("record at %08x has %d bytes, not a multiple of 4", r.Addr, len(r.Data))
+		}
+		// Empty records are kept as they are
+		for off := 0; off < len(r.Data) || off == 0; off += MaxRecordData {
+			end := off + MaxRecordData
+			if end > len(r.Data) {
+				end = len(r.Data)
+			}
+			records = append(records, Record{Addr: r.Addr + uint32(off), Reserved: r.Reserved, Data: r.Data[off:end]})
+		}
+	}
+	plain, err := AppendRecords(nil, records)
+	if err != nil {
+		return nil, 0, err
+	}
+	recordsLen := len(plain) - RecordHeaderSize
+
+	data := make([]byte, ImageHeaderSize+len(plain))
+	Deobfuscate(data[ImageHeaderSize:], plain, 0)
+	binary.BigEndian.PutUint32(data[0:4], img.Signature)
+	binary.BigEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(data[ImageHeaderSize:ImageHeaderSize+recordsLen]))
+	return data, len(records), nil
+}
+
+// AppendRecords appends records in the deobfuscated binary record format
+// followed by the terminator to b. Each record starts with its address, the
+// 3 reserved bytes and its length in words as a single byte, so records can
+// hold at most MaxRecordData bytes.
+func AppendRecords(b []byte, records []Record) ([]byte, error) {
+	for _, r := range records {
+		if len(r.Data)%4 != 0 || len(r.Data) > MaxRecordData {
+			return nil, fmt.Errorf("record at %08x has %d bytes, not a multiple of 4 up to %d", r.Addr, len(r.Data), MaxRecordData)
+		}
+		var rh [RecordHeaderSize]byte
+		binary.BigEndian.PutUint32(rh[0:4], r.Addr)
+		copy(rh[4:7], r.Reserved[:])
+		rh[7] = byte(len(r.Data) / 4)
+		b = append(append(b, rh[:]...), r.Data...)
+	}
+	var term [RecordHeaderSize]byte
+	binary.BigEndian.PutUint32(term[0:4], Terminator)
+	return append(b, term[:]...), nil
+}
+
+// ParseRecords parses deobfuscated records in the binary record format
+// written by AppendRecords up to the terminator or the end of data. Like
+// Image.Records, only the last byte of the length field is the length, the
+// others are returned as Reserved.
+func ParseRecords(data []byte) ([]Record, error) {
+	var records []Record
+	for ptr := 0; ptr < len(data); {
+		if ptr+RecordHeaderSize > len(data) {
+			return nil, fmt.Errorf("truncated record header at offset %d", ptr)
+		}
+		addr := binary.BigEndian.Uint32(data[ptr : ptr+4])
+		if addr == Terminator {
+			break
+		}
+		end := ptr + RecordHeaderSize + int(data[ptr+7])*4
+		if end > len(data) {
+			return nil, fmt.Errorf("record at %08x exceeds data", addr)
+		}
+		r := Record{Addr: addr, Data: data[ptr+RecordHeaderSize : end]}
+		copy(r.Reserved[:], data[ptr+4:ptr+7])
+		records = append(records, r)
+		ptr = end
+	}
+	return records, nil
+}
@@ -0,0 +1,323 @@
+// Package fwpack reads Metanoia firmware packs (firmware_package.b), which
+// contain one or more obfuscated firmware images.
+package fwpack
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+const (
+	// Signature is the signature at the start of every pack.
+	Signature = 0x61232321
+	// HeaderSize is the size of the pack header containing the entries.
+	HeaderSize = 512
+	// EntrySize is the size of a single entry in the header.
+	EntrySize = 32
+	// ImageHeaderSize is the size of the signature and checksum preceding
+	// the records of an image.
+	ImageHeaderSize = 8
+	// RecordHeaderSize is the size of the address and length preceding the
+	// data of a record.
+	RecordHeaderSize = 8
+	// Terminator is the address of the record terminating an image.
+	Terminator = 0xffeeddcc
+)
+
+// SignatureMT5321 identifies the MT-G5321 firmware image.
+const SignatureMT5321 = 0x23210010
+
+// Key is the XOR key the records of all images are obfuscated with. The key
+// position starts at zero with the first record of an image.
+var Key = mustDecodeHex("b4df157369be2ae7d37c55cea6f8ab9d4df1573b9be2ae7637c55ced6f8ab9dadf1573b4be2ae7697c55ced3f8ab9da6f1573b4de2ae769bc55ced378ab9da6f1573b4df2ae769be55ced37cab9da6f8573b4df1ae769be25ced37c5b9da6f8a73b4df15e769be2aced37c559da6f8ab3b4df157769be2aeed37c55cda6f8ab9")
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Header is the fixed part of the pack header.
+type Header struct {
+	Signature uint32
+	Count     uint32
+	Version   uint32
+}
+
+// Entry describes an image in the pack.
+type Entry struct {
+	// Index is the position of the entry in the header, starting at 1.
+	Index     int
+	Signature uint32
+	Size      uint32
+	Offset    uint32
+	Records   uint32
+	// Checksum is the checksum stored in front of the image.
+	Checksum uint32
+	// Raw is the entry as stored, including fields of unknown meaning.
+	Raw [EntrySize]byte
+}
+
+// versions maps known pack versions to their entry parsers.
+var versions = map[uint32]func(index int, raw []byte) Entry{
+	0x20000: parseEntryV2,
+}
+
+func parseEntryV2(index int, raw []byte) Entry {
+	e := Entry{
+		Index:     index,
+		Signature: binary.BigEndian.Uint32(raw[0:4]),
+		Size:      binary.BigEndian.Uint32(raw[4:8]),
+		Offset:    binary.BigEndian.Uint32(raw[16:20]),
+		Records:   binary.BigEndian.Uint32(raw[24:28]),
+	}
+	copy(e.Raw[:], raw)
+	return e
+}
+
+// Pack is an opened firmware pack. Images are only read when requested.
+type Pack struct {
+	r       io.ReaderAt
+	Header  Header
+	Entries []Entry
+}
+
+// IsPack returns true if r starts with the pack signature.
+func IsPack(r io.ReaderAt) bool {
+	var sig [4]byte
+	if _, err := r.ReadAt(sig[:], 0); err != nil {
+		return false
+	}
+	return binary.BigEndian.Uint32(sig[:]) == Signature
+}
+
+// Open parses the header of the pack in r.
+func Open(r io.ReaderAt) (*Pack, error) {
+	raw := make([]byte, HeaderSize)
+	if _, err := r.ReadAt(raw, 0); err != nil {
+		return nil, fmt.Errorf("failed to read pack header: %w", err)
+	}
+	p := &Pack{r: r, Header: Header{
+		Signature: binary.BigEndian.Uint32(raw[0:4]),
+		Count:     binary.BigEndian.Uint32(raw[4:8]),
+		Version:   binary.BigEndian.Uint32(raw[16:20]),
+	}}
+	if p.Header.Signature != Signature {
+		return nil, fmt.Errorf("bad pack signature %x", p.Header.Signature)
+	}
+	parseEntry, ok := versions[p.Header.Version]
+	if !ok {
+		return nil, fmt.Errorf("unknown pack version %x", p.Header.Version)
+	}
+	if p.Header.Count >= HeaderSize/EntrySize {
+		return nil, fmt.Errorf("more firmwares than fit in the header: %d", p.Header.Count)
+	}
+	for i := 1; i <= int(p.Header.Count); i++ {
+		e := parseEntry(i, raw[i*EntrySize:(i+1)*EntrySize])
+		if e.Size < ImageHeaderSize {
+			// Image returns an error for it
+			p.Entries = append(p.Entries, e)
+			continue
+		}
+		var imageHeader [ImageHeaderSize]byte
+		if _, err := r.ReadAt(imageHeader[:], int64(e.Offset)); err != nil {
+			return nil, fmt.Errorf("failed to read header of firmware %d (%08x): %w", i, e.Signature, err)
+		}
+		e.Checksum = binary.BigEndian.Uint32(imageHeader[4:8])
+		p.Entries = append(p.Entries, e)
+	}
+	return p, nil
+}
+
+// ErrNotFound is returned by Find if no image has the signature.
+var ErrNotFound = errors.New("no firmware with this signature in pack")
+
+// Find returns the first entry with the given signature.
+func (p *Pack) Find(signature uint32) (*Entry, error) {
+	for i := range p.Entries {
+		if p.Entries[i].Signature == signature {
+			return &p.Entries[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%08x: %w", signature, ErrNotFound)
+}
+
+// Image reads the image described by e.
+func (p *Pack) Image(e *Entry) (*Image, error) {
+	if e.Size < ImageHeaderSize {
+		return nil, fmt.Errorf("firmware %d (%08x) too small: %d bytes", e.Index, e.Signature, e.Size)
+	}
+	raw := make([]byte, e.Size)
+	if _, err := p.r.ReadAt(raw, int64(e.Offset)); err != nil {
+		return nil, fmt.Errorf("failed to read firmware %d (%08x): %w", e.Index, e.Signature, err)
+	}
+	return &Image{
+		Signature: binary.BigEndian.Uint32(raw[0:4]),
+		Checksum:  binary.BigEndian.Uint32(raw[4:8]),
+		Data:      raw[ImageHeaderSize:],
+	}, nil
+}
+
+// Image is a firmware image read from a pack.
+type Image struct {
+	Signature uint32
+	// Checksum is the checksum stored in the image. It is not known what it
+	// is calculated over or whether it is what the bootloader expects in
+	// DownloadEnd.
+	Checksum uint32
+	// Data are the obfuscated records including the terminator and any data
+	// following it.
+	Data []byte
+}
+
+// Record is a single record of an image.
+type Record struct {
+	Addr uint32
+	// Reserved are the upper bytes of the length field, which are expected
+	// to be zero.
+	Reserved [3]byte
+	// Raw is the obfuscated record as stored, including its header.
+	Raw []byte
+	// Data is the deobfuscated data.
+	Data []byte
+}
+
+// Records returns all records up to the terminator. Only the record headers
+// are deobfuscated to find the boundaries unless deobfuscate is set.
+func (img *Image) Records(deobfuscate bool) ([]Record, error) {
+	var records []Record
+	for ptr := 0; ; {
+		if ptr+RecordHeaderSize > len(img.Data) {
+			return nil, fmt.Errorf("firmware %08x has no terminator record", img.Signature)
+		}
+		var rh [RecordHeaderSize]byte
+		Deobfuscate(rh[:], img.Data[ptr:ptr+RecordHeaderSize], ptr)
+		addr := binary.BigEndian.Uint32(rh[0:4])
+		if addr == Terminator {
+			return records, nil
+		}
+		end := ptr + RecordHeaderSize + int(rh[7])*4
+		if end > len(img.Data) {
+			return nil, fmt.Errorf("record at %x exceeds firmware %08x", addr, img.Signature)
+		}
+		rec := Record{Addr: addr, Raw: img.Data[ptr:end]}
+		copy(rec.Reserved[:], rh[4:7])
+		if deobfuscate {
+			rec.Data = make([]byte, end-ptr-RecordHeaderSize)
+			Deobfuscate(rec.Data, img.Data[ptr+RecordHeaderSize:end], ptr+RecordHeaderSize)
+		}
+		records = append(records, rec)
+		ptr = end
+	}
+}
+
+// RecordsChecksum returns the CRC-32 (IEEE) over all obfuscated records up
+// to the terminator. This is only a guess at what Checksum is calculated
+// over, it has not been compared to a real firmware pack.
+func (img *Image) RecordsChecksum() (uint32, error) {
+	records, err := img.Records(false)
+	if err != nil {
+		return 0, err
+	}
+	h := crc32.NewIEEE()
+	for _, r := range records {
+		h.Write(r.Raw)
+	}
+	return h.Sum32(), nil
+}
+
+// ChecksumCandidate is the CRC-32 (IEEE) over one part of an image the
+// bootloader checksum might be calculated over.
+type ChecksumCandidate struct {
+	Name     string `json:"name"`
+	Checksum uint32 `json:"checksum"`
+}
+
+// ChecksumCandidates returns the CRC-32 over all parts of the image the
+// DownloadEnd checksum is suspected to cover:
+//
+//   - raw: the obfuscated data following the image header
+//   - deobfuscated: the same data deobfuscated
+//   - deobfuscated-4: the same without its last 4 bytes
+//   - records: the obfuscated records up to the terminator
+//   - data: the deobfuscated data of all records without their headers
+//
+// None of them has been shown to be what the bootloader expects.
+func (img *Image) ChecksumCandidates() ([]ChecksumCandidate, error) {
+	records, err := img.Records(true)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(img.Data))
+	Deobfuscate(plain, img.Data, 0)
+	raw, data := crc32.NewIEEE(), crc32.NewIEEE()
+	for _, r := range records {
+		raw.Write(r.Raw)
+		data.Write(r.Data)
+	}
+	candidates := []ChecksumCandidate{
+		{"raw", crc32.ChecksumIEEE(img.Data)},
+		{"deobfuscated", crc32.ChecksumIEEE(plain)},
+		{"deobfuscated-4", 0},
+		{"records", raw.Sum32()},
+		{"data", data.Sum32()},
+	}
+	if len(plain) >= 4 {
+		candidates[2].Checksum = crc32.ChecksumIEEE(plain[:len(plain)-4])
+	}
+	return candidates, nil
+}
+
+// Deobfuscate XORs src with the key starting at key position pos into dst,
+// which needs to be at least as long as src. As XOR is its own inverse, it
+// also obfuscates.
+func Deobfuscate(dst, src []byte, pos int) {
+	for i := range src {
+		dst[i] = src[i] ^ Key[(pos+i)%len(Key)]
+	}
+}
+
+// Segment is contiguous data at Addr.
+type Segment struct {
+	Addr uint32
+	Data []byte
+}
+
+// End returns the address following the segment.
+func (s *Segment) End() uint64 {
+	return uint64(s.Addr) + uint64(len(s.Data))
+}
+
+// Segments sorts the records by address and merges contiguous ones.
+// Overlapping records are kept as separate segments.
+func Segments(records []Record) []Segment {
+	sorted := make([]Record, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Addr < sorted[j].Addr })
+	var segs []Segment
+	for _, r := range sorted {
+		if n := len(segs); n > 0 && segs[n-1].End() == uint64(r.Addr) {
+			segs[n-1].Data = append(segs[n-1].Data, r.Data...)
+			continue
+		}
+		segs = append(segs, Segment{Addr: r.Addr, Data: append([]byte(nil), r.Data...)})
+	}
+	return segs
+}
+
+var chipNames = map[uint32]string{
+	SignatureMT5321: "MT-G5321",
+}
+
+// ChipName returns the name of the chip an image signature belongs to or an
+// empty string if it is not known.
+func ChipName(signature uint32) string {
+	return chipNames[signature]
+}
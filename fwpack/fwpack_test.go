@@ -0,0 +1,189 @@
+package fwpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+// testPack builds a pack with an unrelated image followed by an MT-G5321
+// image with two records.
+func testPack(version uint32) []byte {
+	var plain bytes.Buffer
+	binary.Write(&plain, binary.BigEndian, [2]uint32{0x1000, 2})
+	plain.Write([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+	binary.Write(&plain, binary.BigEndian, [2]uint32{0x60000000, 1})
+	plain.Write([]byte{9, 10, 11, 12})
+	recordsLen := plain.Len()
+	binary.Write(&plain, binary.BigEndian, [2]uint32{Terminator, 0})
+	records := make([]byte, plain.Len())
+	Deobfuscate(records, plain.Bytes(), 0)
+
+	pack := make([]byte, HeaderSize)
+	binary.BigEndian.PutUint32(pack[0:4], Signature)
+	binary.BigEndian.PutUint32(pack[4:8], 2)
+	binary.BigEndian.PutUint32(pack[16:20], version)
+
+	other := []byte{0x12, 0x34, 0x56, 0x78, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(pack[32:36], 0x12345678)
+	binary.BigEndian.PutUint32(pack[36:40], uint32(len(other)))
+	binary.BigEndian.PutUint32(pack[48:52], uint32(len(pack)))
+	pack = append(pack, other...)
+
+	binary.BigEndian.PutUint32(pack[64:68], SignatureMT5321)
+	binary.BigEndian.PutUint32(pack[68:72], uint32(ImageHeaderSize+len(records)))
+	binary.BigEndian.PutUint32(pack[80:84], uint32(len(pack)))
+	binary.BigEndian.PutUint32(pack[88:92], 2)
+	var imageHeader [ImageHeaderSize]byte
+	binary.BigEndian.PutUint32(imageHeader[0:4], SignatureMT5321)
+	binary.BigEndian.PutUint32(imageHeader[4:8], crc32.ChecksumIEEE(records[:recordsLen]))
+	pack = append(pack, imageHeader[:]...)
+	return append(pack, records...)
+}
+
+func TestOpen(t *testing.T) {
+	raw := testPack(0x20000)
+	p, err := Open(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Entries) != 2 || p.Entries[0].Signature != 0x12345678 {
+		t.Fatalf("unexpected entries %+v", p.Entries)
+	}
+	e, err := p.Find(SignatureMT5321)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Index != 2 || e.Records != 2 || e.Offset != HeaderSize+8 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if _, err := p.Find(0xdeadbeef); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	img, err := p.Image(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Checksum != e.Checksum {
+		t.Errorf("image checksum %x differs from entry checksum %x", img.Checksum, e.Checksum)
+	}
+	records, err := img.Records(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Addr != 0x1000 || !bytes.Equal(records[0].Data, []byte{1, 2, 3, 4, 5, 6, 7, 8}) ||
+		records[1].Addr != 0x60000000 || !bytes.Equal(records[1].Data, []byte{9, 10, 11, 12}) {
+		t.Errorf("unexpected records %+v", records)
+	}
+	sum, err := img.RecordsChecksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != img.Checksum {
+		t.Errorf("records checksum %x, expected %x", sum, img.Checksum)
+	}
+
+	if _, err := Open(bytes.NewReader(testPack(0x30000))); err == nil {
+		t.Error("unknown version accepted")
+	}
+	if IsPack(bytes.NewReader([]byte("S00600004844521B"))) {
+		t.Error("S-Record file recognized as pack")
+	}
+}
+
+func TestBuildRoundTrip(t *testing.T) {
+	images := []BuildImage{
+		{Signature: SignatureMT5321, Records: []Record{
+			{Addr: 0x1000, Data: bytes.Repeat([]byte{1, 2, 3, 4}, 10)},
+			{Addr: 0x60000000, Data: []byte{5, 6, 7, 8}},
+			{Addr: 0x2000, Data: nil},
+		}},
+		{Signature: 0x12345678, Records: []Record{
+			{Addr: 0x4000, Data: bytes.Repeat([]byte{0xaa}, MaxRecordData+8)},
+		}},
+	}
+	raw, err := Build(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Open(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Entries) != len(images) {
+		t.Fatalf("expected %d images, got %d", len(images), len(p.Entries))
+	}
+	expected := [][]Record{
+		images[0].Records,
+		// Too long records are split
+		{{Addr: 0x4000, Data: images[1].Records[0].Data[:MaxRecordData]}, {Addr: 0x4000 + MaxRecordData, Data: images[1].Records[0].Data[MaxRecordData:]}},
+	}
+	for i, e := range p.Entries {
+		if e.Signature != images[i].Signature || int(e.Records) != len(expected[i]) {
+			t.Errorf("unexpected entry %+v", e)
+		}
+		img, err := p.Image(&e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := img.Records(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != len(expected[i]) {
+			t.Fatalf("image %d: expected %d records, got %d", i, len(expected[i]), len(records))
+		}
+		for j, r := range records {
+			if r.Addr != expected[i][j].Addr || !bytes.Equal(r.Data, expected[i][j].Data) {
+				t.Errorf("image %d record %d at %x differs", i, j, r.Addr)
+			}
+		}
+		if sum, _ := img.RecordsChecksum(); sum != img.Checksum {
+			t.Errorf("image %d: checksum %x, records checksum %x", i, img.Checksum, sum)
+		}
+		candidates, err := img.ChecksumCandidates()
+		if err != nil || len(candidates) != 5 || candidates[3].Name != "records" || candidates[3].Checksum != img.Checksum {
+			t.Errorf("image %d: unexpected checksum candidates %+v (%v)", i, candidates, err)
+		}
+	}
+
+	if _, err := Build([]BuildImage{{Records: []Record{{Data: []byte{1, 2, 3}}}}}); err == nil {
+		t.Error("record not a multiple of 4 bytes accepted")
+	}
+}
+
+func TestParseRecords(t *testing.T) {
+	in := []Record{
+		{Addr: 0x1000, Data: []byte{1, 2, 3, 4}},
+		{Addr: 0x2000, Reserved: [3]byte{0, 0, 1}, Data: bytes.Repeat([]byte{5}, MaxRecordData)},
+	}
+	plain, err := AppendRecords(nil, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := ParseRecords(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(in) {
+		t.Fatalf("expected %d records, got %+v", len(in), records)
+	}
+	for i, r := range records {
+		if r.Addr != in[i].Addr || r.Reserved != in[i].Reserved || !bytes.Equal(r.Data, in[i].Data) {
+			t.Errorf("record %d: got %+v, expected %+v", i, r, in[i])
+		}
+	}
+	// The length is only the last byte of the length field, like in images
+	if plain[7] != 1 || plain[8+4+7] != MaxRecordData/4 || plain[8+4+6] != 1 {
+		t.Errorf("unexpected record headers %x %x", plain[0:8], plain[12:20])
+	}
+	if _, err := ParseRecords(plain[:10]); err == nil {
+		t.Error("truncated record accepted")
+	}
+	if _, err := AppendRecords(nil, []Record{{Data: make([]byte, MaxRecordData+4)}}); err == nil {
+		t.Error("too long record accepted")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/bootloader"
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+)
+
+type checksumCandidate struct {
+	Name     string `json:"name"`
+	Checksum hex32  `json:"checksum"`
+	// Stored and Known are true if the candidate matches the checksum
+	// stored in the image and bootloader.KnownChecksum.
+	Stored bool `json:"stored"`
+	Known  bool `json:"known"`
+}
+
+type checksumsInfo struct {
+	Signature  hex32               `json:"signature"`
+	Stored     hex32               `json:"stored"`
+	Known      hex32               `json:"known"`
+	Candidates []checksumCandidate `json:"candidates"`
+}
+
+// checksumsMain prints the checksum candidates of an image to find out what
+// the DownloadEnd checksum is calculated over.
+func checksumsMain(args []string) {
+	fs := flag.NewFlagSet("checksums", flag.ExitOnError)
+	signature := fs.String("signature", fmt.Sprintf("%#08x", fwpack.SignatureMT5321), "Signature of the image")
+	jsonOut := fs.Bool("json", false, "Output JSON for scripting")
+	fs.Parse(args)
+	f, p := openPack(fs)
+	defer f.Close()
+	sig, err := strconv.ParseUint(*signature, 0, 32)
+	if err != nil {
+		log.Fatalf("invalid signature: %v", err)
+	}
+	e, err := p.Find(uint32(sig))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	img, err := p.Image(e)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	candidates, err := img.ChecksumCandidates()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	info := checksumsInfo{
+		Signature: hex32(img.Signature),
+		Stored:    hex32(img.Checksum),
+		Known:     bootloader.KnownChecksum,
+	}
+	for _, c := range candidates {
+		info.Candidates = append(info.Candidates, checksumCandidate{
+			Name:     c.Name,
+			Checksum: hex32(c.Checksum),
+			Stored:   c.Checksum == img.Checksum,
+			Known:    c.Checksum == bootloader.KnownChecksum,
+		})
+	}
+	if *jsonOut {
+		printJSON(info)
+		return
+	}
+
+	fmt.Printf("Stored: %08x\nKnown:  %08x\n\n", info.Stored, info.Known)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Candidate\tChecksum\tMatches")
+	for _, c := range info.Candidates {
+		matches := ""
+		if c.Stored {
+			matches += " stored"
+		}
+		if c.Known {
+			matches += " known"
+		}
+		fmt.Fprintf(tw, "%s\t%08x\t%s\n", c.Name, c.Checksum, matches)
+	}
+	tw.Flush()
+}
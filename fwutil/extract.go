@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwexport"
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+	"git.dolansoft.org/lorenz/metanoia-ebm/srec"
+)
+
+// srecData is the maximum amount of data written per S3 record, the
+// largest multiple of 4 fitting into one.
+const srecData = 248
+
+// maxBinarySize limits the size of raw binary output, which grows with the
+// distance between the lowest and highest address.
+const maxBinarySize = 256 << 20
+
+// formats maps output formats to their file extensions.
+var formats = map[string]string{
+	"srec":    ".srec",
+	"ihex":    ".hex",
+	"bin":     ".bin",
+	"elf":     ".elf",
+	"records": ".rec",
+}
+
+// exportOptions control the output format of extractImage.
+type exportOptions struct {
+	format string
+	// base is the start address of raw binaries, the lowest address if
+	// nil.
+	base  *uint32
+	fill  byte
+	entry uint32
+}
+
+type extracted struct {
+	Index     int    `json:"index"`
+	Signature hex32  `json:"signature"`
+	Chip      string `json:"chip,omitempty"`
+	File      string `json:"file"`
+	// Format is the output format or raw for images not in the record
+	// format, which are written as stored.
+	Format  string `json:"format"`
+	Records int    `json:"records"`
+}
+
+func extractMain(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	out := fs.String("out", "", "Path of the file to create for a single image")
+	format := fs.String("format", "srec", "Output format: srec, ihex (Intel HEX), bin (raw binary), elf (ELF32 big-endian Xtensa) or records (deobfuscated binary records)")
+	base := fs.String("base", "", "Start address of raw binaries, defaults to the lowest address")
+	fill := fs.Uint("fill", 0xff, "Byte to fill gaps in raw binaries with")
+	entry := fs.String("entry", "0", "Entry point address for ELF files")
+	signature := fs.String("signature", fmt.Sprintf("%#08x", fwpack.SignatureMT5321), "Signature of the image to extract")
+	all := fs.Bool("all", false, "Extract all images into -out-dir, named after their index and signature")
+	outDir := fs.String("out-dir", ".", "Directory for -all")
+	jsonOut := fs.Bool("json", false, "Output JSON for scripting")
+	fs.Parse(args)
+	f, p := openPack(fs)
+	defer f.Close()
+	opts := exportOptions{format: *format, fill: byte(*fill)}
+	ext, ok := formats[*format]
+	if !ok {
+		log.Fatalf("unknown format %q", *format)
+	}
+	if *fill > 0xff {
+		log.Fatalln("fill needs to be a single byte")
+	}
+	if *base != "" {
+		b, err := strconv.ParseUint(*base, 0, 32)
+		if err != nil {
+			log.Fatalf("invalid base: %v", err)
+		}
+		b32 := uint32(b)
+		opts.base = &b32
+	}
+	entryAddr, err := strconv.ParseUint(*entry, 0, 32)
+	if err != nil {
+		log.Fatalf("invalid entry: %v", err)
+	}
+	opts.entry = uint32(entryAddr)
+
+	var entries []*fwpack.Entry
+	if *all {
+		for i := range p.Entries {
+			entries = append(entries, &p.Entries[i])
+		}
+	} else {
+		if *out == "" {
+			log.Fatalln("out needs to be set")
+		}
+		sig, err := strconv.ParseUint(*signature, 0, 32)
+		if err != nil {
+			log.Fatalf("invalid signature: %v", err)
+		}
+		e, err := p.Find(uint32(sig))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		entries = append(entries, e)
+	}
+
+	var results []extracted
+	for _, e := range entries {
+		path := *out
+		if *all {
+			path = filepath.Join(*outDir, fmt.Sprintf("%d-%08x%s", e.Index, e.Signature, ext))
+		}
+		format := *format
+		n, err := extractImage(p, e, path, opts)
+		if errors.Is(err, errNoRecords) && *all {
+			log.Printf("image %d (%08x): %v, writing it as stored", e.Index, e.Signature, err)
+			format = "raw"
+			path = strings.TrimSuffix(path, ext) + ".raw"
+			err = extractRaw(p, e, path)
+		}
+		if err != nil {
+			log.Fatalf("image %d (%08x): %v", e.Index, e.Signature, err)
+		}
+		results = append(results, extracted{e.Index, hex32(e.Signature), fwpack.ChipName(e.Signature), path, format, n})
+	}
+	if *jsonOut {
+		printJSON(results)
+		return
+	}
+	for _, r := range results {
+		if r.Format == "raw" {
+			fmt.Printf("Image %d (%08x) written as stored to %v\n", r.Index, r.Signature, r.File)
+		} else {
+			fmt.Printf("Image %d (%08x) with %d records written to %v\n", r.Index, r.Signature, r.Records, r.File)
+		}
+	}
+}
+
+var errNoRecords = errors.New("image is not in the record format")
+
+// extractRaw writes the image as stored in the pack to path.
+func extractRaw(p *fwpack.Pack, e *fwpack.Entry, path string) error {
+	img, err := p.Image(e)
+	if err != nil {
+		return err
+	}
+	var header [fwpack.ImageHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], img.Signature)
+	binary.BigEndian.PutUint32(header[4:8], img.Checksum)
+	return os.WriteFile(path, append(header[:], img.Data...), 0644)
+}
+
+// extractImage writes the deobfuscated records of an image to path in the
+// format given by opts and returns the number of records.
+func extractImage(p *fwpack.Pack, e *fwpack.Entry, path string, opts exportOptions) (int, error) {
+	img, err := p.Image(e)
+	if err != nil {
+		return 0, err
+	}
+	records, err := img.Records(true)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errNoRecords, err)
+	}
+	for _, r := range records {
+		if r.Reserved != [3]byte{} {
+			log.Printf("record at %08x has data in reserved area: %x", r.Addr, r.Reserved)
+		}
+	}
+	segs := fwpack.Segments(records)
+
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(out)
+	switch opts.format {
+	case "srec":
+		err = writeSrec(w, records)
+	case "ihex":
+		err = fwexport.WriteIntelHex(w, segs)
+	case "bin":
+		err = writeBinary(w, segs, opts)
+	case "elf":
+		err = fwexport.WriteELF(w, segs, opts.entry)
+	case "records":
+		var plain []byte
+		if plain, err = fwpack.AppendRecords(nil, records); err == nil {
+			_, err = w.Write(plain)
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(path)
+		return 0, err
+	}
+	return len(records), out.Close()
+}
+
+// writeSrec writes records in S-Record format, keeping their order.
+func writeSrec(w *bufio.Writer, records []fwpack.Record) error {
+	w.WriteString(srec.S0("Generated from firmware_package.b by ebm-fwutil"))
+	for _, r := range records {
+		for off := 0; off < len(r.Data); off += srecData {
+			end := off + srecData
+			if end > len(r.Data) {
+				end = len(r.Data)
+			}
+			w.WriteString(srec.S3(r.Addr+uint32(off), r.Data[off:end]))
+		}
+	}
+	return nil
+}
+
+// writeBinary writes segs as raw binary starting at opts.base or the lowest
+// address.
+func writeBinary(w *bufio.Writer, segs []fwpack.Segment, opts exportOptions) error {
+	if len(segs) == 0 {
+		return nil
+	}
+	base := segs[0].Addr
+	if opts.base != nil {
+		base = *opts.base
+	}
+	if base <= segs[0].Addr {
+		if size := segs[len(segs)-1].End() - uint64(base); size > maxBinarySize {
+			return fmt.Errorf("raw binary from %08x would be %d MiB, use another format", base, size>>20)
+		}
+	}
+	return fwexport.WriteBinary(w, segs, base, opts.fill)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+	"git.dolansoft.org/lorenz/metanoia-ebm/srec"
+)
+
+// TestPackExtract checks that extracting a built pack returns the S3 records
+// of the original S-Record file. The S7 entry point is dropped by pack.
+func TestPackExtract(t *testing.T) {
+	dir := t.TempDir()
+	var in strings.Builder
+	in.WriteString(srec.S0("Generated from firmware_package.b by ebm-fwutil"))
+	in.WriteString(srec.S3(0x1000, bytes.Repeat([]byte{1, 2, 3, 4}, srecData/4)))
+	in.WriteString(srec.S3(0x1000+srecData, []byte{5, 6, 7, 8}))
+	in.WriteString(srec.S3(0x60000000, []byte{9, 10, 11, 12}))
+	inPath := filepath.Join(dir, "in.srec")
+	if err := os.WriteFile(inPath, []byte(in.String()+srec.S7(0x1000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := readImageArg(inPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := fwpack.Build([]fwpack.BuildImage{img})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := fwpack.Open(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := p.Find(fwpack.SignatureMT5321)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outPath := filepath.Join(dir, "out.srec")
+	if _, err := extractImage(p, e, outPath, exportOptions{format: "srec"}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != in.String() {
+		t.Errorf("extracted\n%s\nexpected\n%s", out, in.String())
+	}
+
+	// Binary records extracted from a pack build the same pack again
+	recPath := filepath.Join(dir, "out.rec")
+	if _, err := extractImage(p, e, recPath, exportOptions{format: "records"}); err != nil {
+		t.Fatal(err)
+	}
+	img, err = readImageArg(recPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := fwpack.Build([]fwpack.BuildImage{img})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rebuilt, raw) {
+		t.Error("pack built from extracted binary records differs")
+	}
+}
+
+func TestReadImageArg(t *testing.T) {
+	dir := t.TempDir()
+	// Only a number before the first colon is taken as signature
+	path := filepath.Join(dir, "fw:1.rec")
+	raw, err := fwpack.AppendRecords(nil, []fwpack.Record{{Addr: 0x1000, Data: []byte{1, 2, 3, 4}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		arg       string
+		signature uint32
+	}{
+		{path, fwpack.SignatureMT5321},
+		{"0x12345678:" + path, 0x12345678},
+	}
+	for _, c := range cases {
+		img, err := readImageArg(c.arg)
+		if err != nil {
+			t.Errorf("%v: %v", c.arg, err)
+			continue
+		}
+		if img.Signature != c.signature || len(img.Records) != 1 {
+			t.Errorf("%v: got signature %08x and %d records, expected %08x and 1", c.arg, img.Signature, len(img.Records), c.signature)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+)
+
+type packInfo struct {
+	Signature hex32       `json:"signature"`
+	Version   hex32       `json:"version"`
+	Count     uint32      `json:"count"`
+	Images    []imageInfo `json:"images"`
+}
+
+type imageInfo struct {
+	Index     int       `json:"index"`
+	Signature hex32     `json:"signature"`
+	Chip      string    `json:"chip,omitempty"`
+	Offset    uint32    `json:"offset"`
+	Size      uint32    `json:"size"`
+	Records   uint32    `json:"records"`
+	Checksum  hex32     `json:"checksum"`
+	Parsed    bool      `json:"parsed"`
+	Error     string    `json:"error,omitempty"`
+	Ranges    []rangeOf `json:"ranges,omitempty"`
+	// RecordsFound is the number of records up to the terminator.
+	RecordsFound int `json:"records_found"`
+	// RecordsCRCMatches is true if Checksum matches the CRC-32 over the
+	// obfuscated records. This formula is unverified, so a mismatch does
+	// not mean that the image is corrupt.
+	RecordsCRCMatches bool `json:"records_crc_matches_unverified"`
+}
+
+type rangeOf struct {
+	Start hex32 `json:"start"`
+	End   hex32 `json:"end"`
+}
+
+func (r rangeOf) String() string {
+	return fmt.Sprintf("%08x-%08x", uint32(r.Start), uint32(r.End))
+}
+
+// describeImage reads an image and collects its information. Images which
+// can't be parsed are reported in Error.
+func describeImage(p *fwpack.Pack, e *fwpack.Entry) imageInfo {
+	info := imageInfo{
+		Index:     e.Index,
+		Signature: hex32(e.Signature),
+		Chip:      fwpack.ChipName(e.Signature),
+		Offset:    e.Offset,
+		Size:      e.Size,
+		Records:   e.Records,
+		Checksum:  hex32(e.Checksum),
+	}
+	img, err := p.Image(e)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	records, err := img.Records(true)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Parsed = true
+	info.RecordsFound = len(records)
+	for _, s := range fwpack.Segments(records) {
+		info.Ranges = append(info.Ranges, rangeOf{hex32(s.Addr), hex32(s.End() - 1)})
+	}
+	sum, _ := img.RecordsChecksum()
+	info.RecordsCRCMatches = sum == img.Checksum
+	return info
+}
+
+func infoMain(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Output JSON for scripting")
+	fs.Parse(args)
+	f, p := openPack(fs)
+	defer f.Close()
+
+	info := packInfo{
+		Signature: hex32(p.Header.Signature),
+		Version:   hex32(p.Header.Version),
+		Count:     p.Header.Count,
+	}
+	for i := range p.Entries {
+		info.Images = append(info.Images, describeImage(p, &p.Entries[i]))
+	}
+	if *jsonOut {
+		printJSON(info)
+		return
+	}
+
+	fmt.Printf("Signature: %08x\nVersion:   %08x\nImages:    %d\n\n", info.Signature, info.Version, info.Count)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tSignature\tChip\tOffset\tSize\tRecords\tChecksum\tRanges")
+	for _, img := range info.Images {
+		chip := img.Chip
+		if chip == "" {
+			chip = "unknown"
+		}
+		records, checksum, ranges := fmt.Sprint(img.Records), fmt.Sprintf("%08x", img.Checksum), ""
+		if img.Parsed {
+			if img.RecordsFound != int(img.Records) {
+				records = fmt.Sprintf("%d (%d found)", img.Records, img.RecordsFound)
+			}
+			if img.RecordsCRCMatches {
+				checksum += " (records CRC, unverified)"
+			}
+			var rs []string
+			for _, r := range img.Ranges {
+				rs = append(rs, r.String())
+			}
+			ranges = strings.Join(rs, " ")
+		} else {
+			ranges = "not parsed: " + img.Error
+		}
+		fmt.Fprintf(tw, "%d\t%08x\t%s\t%d\t%d\t%s\t%s\t%s\n", img.Index, img.Signature, chip, img.Offset, img.Size, records, checksum, ranges)
+	}
+	tw.Flush()
+}
@@ -1,141 +1,102 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
-	"encoding/hex"
-	"errors"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"hash/crc32"
-	"io"
 	"log"
 	"os"
+	"sort"
+	"strings"
 
-	"git.dolansoft.org/lorenz/metanoia-ebm/srec"
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
 )
 
-var (
-	fwPackPath = flag.String("fw-pack", "", "Path to the Metanoia firmware pack")
-	outPath    = flag.String("out", "", "Path where the Motorola S-Rec file with the deobfuscated firmware should be created")
-)
+type command struct {
+	usage string
+	run   func(args []string)
+}
 
-func main() {
-	flag.Parse()
-	if *fwPackPath == "" {
-		log.Fatalln("fw-pack needs to be set")
-	}
-	if *outPath == "" {
-		log.Fatalln("out needs to be set")
-	}
-	firmware, err := os.Open(*fwPackPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	key, err := hex.DecodeString("b4df157369be2ae7d37c55cea6f8ab9d4df1573b9be2ae7637c55ced6f8ab9dadf1573b4be2ae7697c55ced3f8ab9da6f1573b4de2ae769bc55ced378ab9da6f1573b4df2ae769be55ced37cab9da6f8573b4df1ae769be25ced37c5b9da6f8a73b4df15e769be2aced37c559da6f8ab3b4df157769be2aeed37c55cda6f8ab9")
-	if err != nil {
-		log.Fatal(err)
-	}
+var commands = map[string]command{
+	"info":      {"Print the header and all images of a firmware pack", infoMain},
+	"extract":   {"Deobfuscate images of a firmware pack into S-Record, Intel HEX, binary or ELF files", extractMain},
+	"pack":      {"Build a firmware pack from deobfuscated images", packMain},
+	"checksums": {"Print the DownloadEnd checksum candidates of an image", checksumsMain},
+}
 
-	outHex, err := os.Create(*outPath)
-	if err != nil {
-		log.Fatal(err)
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] <args>\n\nCommands:\n", os.Args[0])
+	var names []string
+	for name := range commands {
+		names = append(names, name)
 	}
-
-	header := make([]byte, 512)
-	if _, err := io.ReadFull(firmware, header); err != nil {
-		log.Fatal(err)
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
 	}
-	packSignature := binary.BigEndian.Uint32(header[:4])
-	if packSignature != 0x61232321 {
-		log.Fatalf("Bad signature %x", packSignature)
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
 	}
-	packVersion := binary.BigEndian.Uint32(header[16:20])
-	if packVersion != 0x20000 {
-		log.Fatalf("Unknown pack version %x", packVersion)
+	// fwutil -fw-pack <pack> -out <file> from before the subcommands
+	if strings.HasPrefix(os.Args[1], "-") {
+		legacyMain(os.Args[1:])
+		return
 	}
-	numberOfFirmwares := binary.BigEndian.Uint32(header[4:8])
-	if numberOfFirmwares*32 >= uint32(len(header)) {
-		log.Fatalf("More firmwares than fit in the header: %d", numberOfFirmwares)
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
 	}
-	var mt5321FwIdx int = -1
-	for i := 1; i <= int(numberOfFirmwares); i++ {
-		firmwareSig := binary.BigEndian.Uint32(header[i*32 : (i*32)+4])
-		if firmwareSig == 0x23210010 {
-			mt5321FwIdx = i
-			break
-		}
+	cmd.run(os.Args[2:])
+}
+
+func legacyMain(args []string) {
+	fs := flag.NewFlagSet("fwutil", flag.ExitOnError)
+	fwPackPath := fs.String("fw-pack", "", "Path to the Metanoia firmware pack")
+	outPath := fs.String("out", "", "Path where the Motorola S-Rec file with the deobfuscated firmware should be created")
+	fs.Parse(args)
+	if *fwPackPath == "" {
+		log.Fatalln("fw-pack needs to be set")
 	}
-	if mt5321FwIdx == -1 {
-		log.Fatalf("No MT5321 firmware signature found in firmware file")
+	if *outPath == "" {
+		log.Fatalln("out needs to be set")
 	}
+	extractMain([]string{"-out", *outPath, *fwPackPath})
+}
 
-	metaStart := 32 * mt5321FwIdx
-	fwStartOffset := binary.BigEndian.Uint32(header[metaStart+16 : metaStart+20])
-	numberOfRecords := binary.BigEndian.Uint32(header[metaStart+24 : metaStart+28])
-	fwSize := binary.BigEndian.Uint32(header[metaStart+4 : metaStart+8])
-	fmt.Printf("fwStartOffset: %d\n", fwStartOffset)
-	fmt.Printf("numberOfRecords: %d\n", numberOfRecords)
-	fmt.Printf("fwSize: %d\n", fwSize)
-	_, err = firmware.Seek(int64(fwStartOffset), io.SeekStart)
+// openPack opens the pack given as the only positional argument of fs.
+func openPack(fs *flag.FlagSet) (*os.File, *fwpack.Pack) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(fs.Arg(0))
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalln(err)
 	}
-
-	fwDataR := io.LimitReader(firmware, int64(fwSize))
-
-	var signature, checksum uint32
-
-	cksum := crc32.NewIEEE()
-
-	binary.Read(fwDataR, binary.BigEndian, &signature)
-	binary.Read(fwDataR, binary.BigEndian, &checksum)
-
-	var fwData []byte
-
-	buf := make([]byte, 4096)
-
-	for {
-		n, err := fwDataR.Read(buf)
-		if errors.Is(err, io.EOF) {
-			break
-		}
-		cksum.Write(buf[:n])
-		for _, b := range buf[:n] {
-			fwData = append(fwData, b^key[len(fwData)%len(key)])
-		}
+	p, err := fwpack.Open(f)
+	if err != nil {
+		log.Fatalf("%v: %v", fs.Arg(0), err)
 	}
+	return f, p
+}
 
-	fmt.Printf("checksum raw: %x\n", cksum.Sum32())
-	fmt.Printf("checksum deobfuscated: %x\n", crc32.ChecksumIEEE(fwData))
-	fmt.Printf("checksum deobfuscated -4 bytes: %x\n", crc32.ChecksumIEEE(fwData[:len(fwData)-4]))
-
-	outHex.WriteString(srec.S0("Generated from firmware_package.b by ebm-fwutil"))
+// hex32 is a uint32 encoded as hex string in JSON.
+type hex32 uint32
 
-	dataSum := crc32.NewIEEE()
+func (h hex32) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("0x%08x", uint32(h))), nil
+}
 
-	var ptr int
-	for {
-		if ptr+8 > len(fwData) {
-			break
-		}
-		record := fwData[ptr : ptr+8]
-		addr := binary.BigEndian.Uint32(record[:4])
-		if addr == 0xffeeddcc {
-			break
-		}
-		recordSize := int(record[7]) * 4
-		if !bytes.Equal(record[4:7], []byte{0, 0, 0}) {
-			fmt.Printf("data in reserved area: %x %v %v\n", addr, record[4:7], recordSize)
-		}
-		ptr += 8
-		dataSum.Write(fwData[ptr : ptr+recordSize])
-		outHex.WriteString(srec.S3(addr, fwData[ptr:ptr+recordSize]))
-		ptr += recordSize
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalln(err)
 	}
-
-	fmt.Printf("checksum data only: %x\n", dataSum.Sum32())
-
-	outHex.Close()
-	fmt.Println("done")
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/fwpack"
+	"git.dolansoft.org/lorenz/metanoia-ebm/srec"
+)
+
+func packMain(args []string) {
+	fs := flag.NewFlagSet("pack", flag.ExitOnError)
+	out := fs.String("out", "", "Path of the firmware pack to create")
+	jsonOut := fs.Bool("json", false, "Output JSON for scripting")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s pack -out <pack> [signature:]<image>...\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Images are deobfuscated S-Record or binary record files as written by\nextract -format records, the signature defaults to %#08x (%v).\n\n", fwpack.SignatureMT5321, fwpack.ChipName(fwpack.SignatureMT5321))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *out == "" || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var images []fwpack.BuildImage
+	for _, arg := range fs.Args() {
+		img, err := readImageArg(arg)
+		if err != nil {
+			log.Fatalf("%v: %v", arg, err)
+		}
+		images = append(images, img)
+	}
+	pack, err := fwpack.Build(images)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := os.WriteFile(*out, pack, 0644); err != nil {
+		log.Fatalln(err)
+	}
+
+	p, err := fwpack.Open(bytes.NewReader(pack))
+	if err != nil {
+		log.Fatalf("built pack can't be read back: %v", err)
+	}
+	if *jsonOut {
+		var infos []imageInfo
+		for i := range p.Entries {
+			infos = append(infos, describeImage(p, &p.Entries[i]))
+		}
+		printJSON(infos)
+		return
+	}
+	for _, e := range p.Entries {
+		fmt.Printf("Image %d (%08x) with %d records and checksum %08x\n", e.Index, e.Signature, e.Records, e.Checksum)
+	}
+	fmt.Printf("Pack written to %v\n", *out)
+}
+
+// readImageArg reads an image given as [signature:]path. The part before
+// the first colon is only taken as signature if it is a number, so paths
+// containing colons can be given without one.
+func readImageArg(arg string) (fwpack.BuildImage, error) {
+	img := fwpack.BuildImage{Signature: fwpack.SignatureMT5321}
+	path := arg
+	if sigStr, rest, ok := strings.Cut(arg, ":"); ok {
+		if sig, err := strconv.ParseUint(sigStr, 0, 32); err == nil {
+			img.Signature, path = uint32(sig), rest
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return img, err
+	}
+	if len(data) >= 2 && data[0] == 'S' && data[1] >= '0' && data[1] <= '9' {
+		img.Records, err = parseSrecRecords(data)
+	} else {
+		img.Records, err = fwpack.ParseRecords(data)
+	}
+	return img, err
+}
+
+// parseSrecRecords returns the S3 records of an S-Record file. S0, S5, S6
+// and S7 records are ignored as a pack has no place for them, so the entry
+// point is not preserved.
+func parseSrecRecords(data []byte) ([]fwpack.Record, error) {
+	var records []fwpack.Record
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		typ, payload, err := srec.ParseGeneric(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		switch typ {
+		case 0, 5, 6, 7:
+		case 3:
+			if len(payload) < 4 {
+				return nil, fmt.Errorf("line %d: S3 record without address", lineNo)
+			}
+			records = append(records, fwpack.Record{Addr: binary.BigEndian.Uint32(payload[0:4]), Data: payload[4:]})
+		default:
+			return nil, fmt.Errorf("line %d: S%d records are not supported, only S3", lineNo, typ)
+		}
+	}
+	return records, s.Err()
+}
@@ -0,0 +1,304 @@
+// Package mqtt implements a minimal MQTT 3.1.1 client which can only publish
+// messages with QoS 0. It is just enough for exporting modem status to a
+// broker without pulling in a full client library.
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Packet types
+const (
+	typeConnect    = 1
+	typeConnAck    = 2
+	typePublish    = 3
+	typePingReq    = 12
+	typePingResp   = 13
+	typeDisconnect = 14
+)
+
+// Connect flags
+const (
+	flagCleanSession = 0x02
+	flagWill         = 0x04
+	flagWillRetain   = 0x20
+	flagPassword     = 0x40
+	flagUsername     = 0x80
+)
+
+var connAckDesc = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// ErrClosed is returned when publishing on a closed client.
+var ErrClosed = errors.New("mqtt connection closed")
+
+// Message is an application message.
+type Message struct {
+	Topic   string
+	Payload []byte
+	// Retain asks the broker to keep the message and send it to future
+	// subscribers of the topic.
+	Retain bool
+}
+
+// Options configure a client.
+type Options struct {
+	// Addr is the TCP address of the broker, like "localhost:1883".
+	Addr     string
+	ClientID string
+	Username string
+	Password string
+	// KeepAlive is the maximum time between two packets sent to the broker.
+	// Pings are sent if nothing else is published. Defaults to 60s.
+	KeepAlive time.Duration
+	// Will is published by the broker if the client disconnects without
+	// closing the connection.
+	Will *Message
+}
+
+// Client is a connection to an MQTT broker. It is safe for concurrent use.
+type Client struct {
+	conn      net.Conn
+	keepAlive time.Duration
+
+	mu       sync.Mutex
+	w        *bufio.Writer
+	lastSent time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// Dial connects to the broker and waits for it to accept the connection.
+func Dial(opts Options) (*Client, error) {
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = 60 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", opts.Addr, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to broker: %w", err)
+	}
+	c, err := newClient(conn, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newClient(conn net.Conn, opts Options) (*Client, error) {
+	c := &Client{
+		conn:      conn,
+		keepAlive: opts.KeepAlive,
+		w:         bufio.NewWriter(conn),
+		done:      make(chan struct{}),
+	}
+	if err := c.send(typeConnect<<4, connectPacket(&opts)); err != nil {
+		return nil, fmt.Errorf("failed to send connect: %w", err)
+	}
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	header, body, err := readPacket(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read connack: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if header>>4 != typeConnAck || len(body) != 2 {
+		return nil, fmt.Errorf("expected connack, got packet type %d", header>>4)
+	}
+	if code := body[1]; code != 0 {
+		desc := connAckDesc[code]
+		if desc == "" {
+			desc = fmt.Sprintf("unknown return code %d", code)
+		}
+		return nil, fmt.Errorf("broker refused connection: %v", desc)
+	}
+	go c.reader(r)
+	go c.pinger()
+	return c, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint16(b, uint16(len(s))), s...)
+}
+
+func connectPacket(opts *Options) []byte {
+	flags := byte(flagCleanSession)
+	if opts.Will != nil {
+		flags |= flagWill
+		if opts.Will.Retain {
+			flags |= flagWillRetain
+		}
+	}
+	if opts.Username != "" {
+		flags |= flagUsername
+	}
+	if opts.Password != "" {
+		flags |= flagPassword
+	}
+	b := appendString(nil, "MQTT")
+	b = append(b, 4, flags)
+	b = appendUint16(b, uint16(opts.KeepAlive/time.Second))
+	b = appendString(b, opts.ClientID)
+	if opts.Will != nil {
+		b = appendString(b, opts.Will.Topic)
+		b = appendString(b, string(opts.Will.Payload))
+	}
+	if opts.Username != "" {
+		b = appendString(b, opts.Username)
+	}
+	if opts.Password != "" {
+		b = appendString(b, opts.Password)
+	}
+	return b
+}
+
+// appendRemainingLength appends the variable-length encoding of n.
+func appendRemainingLength(b []byte, n int) []byte {
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+// readPacket reads a packet and returns its first header byte and body.
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n, shift int
+	for {
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(d&0x7f) << shift
+		if d&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift > 21 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func (c *Client) send(header byte, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w.WriteByte(header)
+	c.w.Write(appendRemainingLength(nil, len(body)))
+	c.w.Write(body)
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	c.lastSent = time.Now()
+	return nil
+}
+
+// Publish sends m with QoS 0.
+func (c *Client) Publish(m Message) error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+	header := byte(typePublish << 4)
+	if m.Retain {
+		header |= 0x01
+	}
+	body := appendString(nil, m.Topic)
+	body = append(body, m.Payload...)
+	if err := c.send(header, body); err != nil {
+		c.shutdown(err)
+		return fmt.Errorf("failed to publish: %w", err)
+	}
+	return nil
+}
+
+// reader consumes packets from the broker. As only QoS 0 messages are
+// published, the only expected packets are ping responses.
+func (c *Client) reader(r *bufio.Reader) {
+	for {
+		if _, _, err := readPacket(r); err != nil {
+			c.shutdown(err)
+			return
+		}
+	}
+}
+
+func (c *Client) pinger() {
+	t := time.NewTicker(c.keepAlive / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			c.mu.Lock()
+			idle := time.Since(c.lastSent)
+			c.mu.Unlock()
+			if idle < c.keepAlive/2 {
+				continue
+			}
+			if err := c.send(typePingReq<<4, nil); err != nil {
+				c.shutdown(err)
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// Done returns a channel which is closed when the connection shuts down.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the connection shut down after Done is closed.
+func (c *Client) Err() error {
+	return c.err
+}
+
+// Close disconnects cleanly, the broker does not publish the will.
+func (c *Client) Close() error {
+	err := c.send(typeDisconnect<<4, nil)
+	c.shutdown(ErrClosed)
+	return err
+}
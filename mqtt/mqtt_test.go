@@ -0,0 +1,94 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRemainingLength(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 16383, 16384, 2097151} {
+		raw := appendRemainingLength([]byte{0x30}, n)
+		r := bufio.NewReader(bytes.NewReader(append(raw, make([]byte, n)...)))
+		_, body, err := readPacket(r)
+		if err != nil {
+			t.Fatalf("%d: %v", n, err)
+		}
+		if len(body) != n {
+			t.Errorf("expected remaining length %d, got %d", n, len(body))
+		}
+	}
+}
+
+// fakeBroker accepts a single connection and returns the packets it
+// received on the returned channel.
+func fakeBroker(conn net.Conn) <-chan []byte {
+	packets := make(chan []byte, 10)
+	go func() {
+		defer close(packets)
+		r := bufio.NewReader(conn)
+		for {
+			header, body, err := readPacket(r)
+			if err != nil {
+				return
+			}
+			if header>>4 == typeConnect {
+				conn.Write([]byte{typeConnAck << 4, 2, 0, 0})
+			}
+			packets <- append([]byte{header}, body...)
+		}
+	}()
+	return packets
+}
+
+func next(t *testing.T, packets <-chan []byte) []byte {
+	select {
+	case p := <-packets:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet received")
+		return nil
+	}
+}
+
+func TestPublish(t *testing.T) {
+	clientConn, brokerConn := net.Pipe()
+	packets := fakeBroker(brokerConn)
+	c, err := newClient(clientConn, Options{
+		ClientID:  "ebm",
+		KeepAlive: time.Minute,
+		Will:      &Message{Topic: "ebm/availability", Payload: []byte("offline"), Retain: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect := next(t, packets)
+	expected := []byte{typeConnect << 4, 0, 4, 'M', 'Q', 'T', 'T', 4, flagCleanSession | flagWill | flagWillRetain, 0, 60,
+		0, 3, 'e', 'b', 'm',
+		0, 16, 'e', 'b', 'm', '/', 'a', 'v', 'a', 'i', 'l', 'a', 'b', 'i', 'l', 'i', 't', 'y',
+		0, 7, 'o', 'f', 'f', 'l', 'i', 'n', 'e'}
+	if !bytes.Equal(connect, expected) {
+		t.Errorf("unexpected connect packet\n%x\nexpected\n%x", connect, expected)
+	}
+
+	if err := c.Publish(Message{Topic: "ebm/state", Payload: []byte("SHOWTIME"), Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+	publish := next(t, packets)
+	expected = []byte{typePublish<<4 | 1, 0, 9, 'e', 'b', 'm', '/', 's', 't', 'a', 't', 'e', 'S', 'H', 'O', 'W', 'T', 'I', 'M', 'E'}
+	if !bytes.Equal(publish, expected) {
+		t.Errorf("unexpected publish packet\n%x\nexpected\n%x", publish, expected)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if p := next(t, packets); p[0] != typeDisconnect<<4 {
+		t.Errorf("expected disconnect, got %x", p)
+	}
+	if err := c.Publish(Message{Topic: "ebm/state"}); err != ErrClosed {
+		t.Errorf("expected ErrClosed after close, got %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+// Package pm implements G.997.1-style performance monitoring on top of the
+// running counters exposed by the modem. It samples them regularly and
+// accumulates the differences into 15-minute and 1-day bins which are
+// persisted to disk.
+package pm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"git.dolansoft.org/lorenz/metanoia-ebm/ebm"
+)
+
+// Counter is a running counter monitored by the engine.
+type Counter struct {
+	Name string
+	OID  *ebm.OID
+}
+
+// Counters are all counters monitored by the engine.
+var Counters = []Counter{
+	{"ne_es", &ebm.OidNearEndErroredSeconds},
+	{"fe_es", &ebm.OidFarEndErroredSeconds},
+	{"ne_ses", &ebm.OidNearEndSeverelyErroredSeconds},
+	{"fe_ses", &ebm.OidFarEndSeverelyErroredSeconds},
+	{"ne_uas", &ebm.OidNearEndUnavailableSeconds},
+	{"fe_uas", &ebm.OidFarEndUnavailableSeconds},
+	{"ne_loss", &ebm.OidNearEndLossOfSignalSeconds},
+	{"fe_loss", &ebm.OidFarEndLossOfSignalSeconds},
+	{"ne_lors", &ebm.OidNearEndLossOfRMCSeconds},
+	{"fe_lors", &ebm.OidFarEndLossOfRMCSeconds},
+	{"ne_cv", &ebm.OidNearEndCodeViolations},
+	{"fe_cv", &ebm.OidFarEndCodeViolations},
+	{"ne_uncorrected_dtu", &ebm.OidNearEndUncorrectedDTU},
+	{"fe_uncorrected_dtu", &ebm.OidFarEndUncorrectedDTU},
+	{"ne_retransmitted_dtu", &ebm.OidNearEndRetransmittedDTU},
+	{"fe_retransmitted_dtu", &ebm.OidFarEndRetransmittedDTU},
+	{"full_inits", &ebm.OidFullInits},
+	{"failed_full_inits", &ebm.OidFailedFullInits},
+}
+
+// Interval is the length of a PM bin.
+type Interval string
+
+const (
+	Interval15Min Interval = "15m"
+	Interval1Day  Interval = "1d"
+)
+
+// Intervals are all supported intervals.
+var Intervals = []Interval{Interval15Min, Interval1Day}
+
+// start returns the start of the bin of interval i containing t.
+func (i Interval) start(t time.Time) time.Time {
+	switch i {
+	case Interval15Min:
+		return t.Truncate(15 * time.Minute)
+	case Interval1Day:
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	default:
+		panic("unknown interval " + i)
+	}
+}
+
+// end returns the end of the bin of interval i starting at start.
+func (i Interval) end(start time.Time) time.Time {
+	if i == Interval1Day {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.Add(15 * time.Minute)
+}
+
+// Sample is a single reading of all counters.
+type Sample struct {
+	Time   time.Time         `json:"time"`
+	Ticks  uint32            `json:"ticks"`
+	Values map[string]uint32 `json:"values"`
+}
+
+// Bin contains the counter increments within a single interval.
+type Bin struct {
+	Interval Interval          `json:"interval"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Counts   map[string]uint64 `json:"counts"`
+	// Suspect is set if the counts might not be complete, for example
+	// because no samples were taken for parts of the interval or the
+	// counters were reset.
+	Suspect bool `json:"suspect"`
+}
+
+func newBin(i Interval, t time.Time) *Bin {
+	start := i.start(t)
+	return &Bin{
+		Interval: i,
+		Start:    start,
+		End:      i.end(start),
+		Counts:   make(map[string]uint64),
+	}
+}
+
+// state is the persisted state of the engine.
+type state struct {
+	Last    *Sample             `json:"last"`
+	Current map[Interval]*Bin   `json:"current"`
+	History map[Interval][]*Bin `json:"history"`
+}
+
+// Engine samples counters and accumulates them into bins. It is safe for
+// concurrent use.
+type Engine struct {
+	path string
+	// Keep is the number of completed bins kept per interval.
+	Keep map[Interval]int
+	// MaxGap is the longest time between two samples for them to be
+	// considered continuous, generally twice the sampling interval. Bins
+	// with longer gaps are suspect.
+	MaxGap time.Duration
+
+	mu sync.Mutex
+	s  state
+}
+
+// Open opens an engine persisting its state at path. Existing state is
+// loaded and continued from.
+func Open(path string) (*Engine, error) {
+	e := &Engine{
+		path: path,
+		Keep: map[Interval]int{
+			Interval15Min: 96,
+			Interval1Day:  31,
+		},
+		MaxGap: time.Minute,
+		s: state{
+			Current: make(map[Interval]*Bin),
+			History: make(map[Interval][]*Bin),
+		},
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PM state: %w", err)
+	}
+	if err := json.Unmarshal(raw, &e.s); err != nil {
+		return nil, fmt.Errorf("failed to parse PM state %v: %w", path, err)
+	}
+	if e.s.Current == nil {
+		e.s.Current = make(map[Interval]*Bin)
+	}
+	if e.s.History == nil {
+		e.s.History = make(map[Interval][]*Bin)
+	}
+	return e, nil
+}
+
+// counterDelta returns the increment of a counter between two readings. A
+// counter which went backwards is considered to have wrapped if it was in the
+// upper quarter and now is in the lower quarter of its range, otherwise it is
+// considered to have been reset to zero.
+func counterDelta(prev, cur uint32) (delta uint32, reset bool) {
+	if cur >= prev {
+		return cur - prev, false
+	}
+	if prev >= 0xc0000000 && cur < 0x40000000 {
+		return cur - prev, false // Wraps around in uint32 arithmetic
+	}
+	return cur, true
+}
+
+// Sample reads all counters from the modem and adds them to the engine.
+func (e *Engine) Sample(c *ebm.Conn) error {
+	oids := []*ebm.OID{&ebm.OidTicks}
+	for _, ctr := range Counters {
+		oids = append(oids, ctr.OID)
+	}
+	now := time.Now()
+	v, err := c.ReadMIBs(oids...)
+	if err != nil {
+		return fmt.Errorf("failed to read PM counters: %w", err)
+	}
+	ticks, ok := v[0].(uint32)
+	if !ok {
+		return fmt.Errorf("ticks have unexpected type %T", v[0])
+	}
+	s := Sample{Time: now, Ticks: ticks, Values: make(map[string]uint32)}
+	for i, ctr := range Counters {
+		if s.Values[ctr.Name], ok = v[i+1].(uint32); !ok {
+			return fmt.Errorf("counter %v has unexpected type %T", ctr.Name, v[i+1])
+		}
+	}
+	return e.Add(s)
+}
+
+// Add processes a sample and persists the resulting state. The increments
+// since the previous sample are assumed to be spread evenly over the time
+// between them, increments across the end of a bin are split between the
+// bins accordingly. If the sample is not after the previous one, its
+// increments are dropped and the current bins are marked suspect.
+func (e *Engine) Add(s Sample) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	last := e.s.Last
+	var deltas map[string]uint64
+	var anyReset bool
+	if last != nil && s.Time.After(last.Time) {
+		// If ticks went backwards the modem has rebooted and all
+		// counters started from zero.
+		_, rebooted := counterDelta(last.Ticks, s.Ticks)
+		deltas = make(map[string]uint64)
+		for name, val := range s.Values {
+			delta, reset := counterDelta(last.Values[name], val)
+			if rebooted {
+				delta = val
+			}
+			anyReset = anyReset || reset || rebooted
+			deltas[name] = uint64(delta)
+		}
+	}
+	// Samples are missing if there is no previous one or it is too old.
+	// If the clock went backwards the increments since the previous sample
+	// are lost.
+	gap := last == nil || s.Time.Sub(last.Time) > e.MaxGap || !s.Time.After(last.Time)
+
+	for _, i := range Intervals {
+		cur := e.s.Current[i]
+		rest := deltas
+		if cur != nil && !s.Time.Before(cur.End) {
+			if deltas != nil && !last.Time.Before(cur.Start) {
+				rest = make(map[string]uint64, len(deltas))
+				share := float64(cur.End.Sub(last.Time)) / float64(s.Time.Sub(last.Time))
+				for name, d := range deltas {
+					n := uint64(float64(d) * share)
+					cur.Counts[name] += n
+					rest[name] = d - n
+				}
+				if anyReset {
+					cur.Suspect = true
+				}
+			}
+			e.s.History[i] = append([]*Bin{cur}, e.s.History[i]...)
+			if keep := e.Keep[i]; len(e.s.History[i]) > keep {
+				e.s.History[i] = e.s.History[i][:keep]
+			}
+			cur = nil
+		}
+		if cur == nil {
+			cur = newBin(i, s.Time)
+			e.s.Current[i] = cur
+		}
+		for name, d := range rest {
+			cur.Counts[name] += d
+		}
+		if gap || anyReset {
+			cur.Suspect = true
+		}
+	}
+	e.s.Last = &s
+	return e.save()
+}
+
+// save atomically writes the state to disk.
+func (e *Engine) save() error {
+	raw, err := json.Marshal(&e.s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(e.path), ".pm-state-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary PM state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write PM state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write PM state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), e.path); err != nil {
+		return fmt.Errorf("failed to replace PM state: %w", err)
+	}
+	return nil
+}
+
+// Current returns a copy of the currently running bin of the given interval
+// or nil if there is none.
+func (e *Engine) Current(i Interval) *Bin {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.s.Current[i] == nil {
+		return nil
+	}
+	return e.s.Current[i].copy()
+}
+
+// Query returns copies of all completed bins of the given interval which
+// overlap with [from, to), newest first. Zero times are unbounded.
+func (e *Engine) Query(i Interval, from, to time.Time) []*Bin {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var res []*Bin
+	for _, b := range e.s.History[i] {
+		if !from.IsZero() && !b.End.After(from) {
+			continue
+		}
+		if !to.IsZero() && !b.Start.Before(to) {
+			continue
+		}
+		res = append(res, b.copy())
+	}
+	return res
+}
+
+func (b *Bin) copy() *Bin {
+	c := *b
+	c.Counts = make(map[string]uint64, len(b.Counts))
+	for k, v := range b.Counts {
+		c.Counts[k] = v
+	}
+	return &c
+}
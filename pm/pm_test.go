@@ -0,0 +1,139 @@
+package pm
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCounterDelta(t *testing.T) {
+	cases := []struct {
+		prev, cur uint32
+		delta     uint32
+		reset     bool
+	}{
+		{10, 15, 5, false},
+		{0xfffffffe, 3, 5, false},
+		{1000, 10, 10, true},
+	}
+	for _, c := range cases {
+		delta, reset := counterDelta(c.prev, c.cur)
+		if delta != c.delta || reset != c.reset {
+			t.Errorf("counterDelta(%d, %d) = %d, %v; expected %d, %v", c.prev, c.cur, delta, reset, c.delta, c.reset)
+		}
+	}
+}
+
+func sample(t time.Time, ticks, es uint32) Sample {
+	return Sample{Time: t, Ticks: ticks, Values: map[string]uint32{"ne_es": es}}
+}
+
+func TestEngine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pm.json")
+	e, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2022, 11, 1, 10, 0, 0, 0, time.UTC)
+	steps := []Sample{
+		sample(base, 1000, 100),
+		sample(base.Add(5*time.Minute), 2000, 103),
+		sample(base.Add(10*time.Minute), 3000, 104),
+		// Next interval
+		sample(base.Add(15*time.Minute), 4000, 110),
+	}
+	for _, s := range steps {
+		if err := e.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hist := e.Query(Interval15Min, time.Time{}, time.Time{})
+	if len(hist) != 1 {
+		t.Fatalf("expected 1 completed bin, got %d", len(hist))
+	}
+	// The increments up to the sample at the end of the bin belong to it
+	if hist[0].Counts["ne_es"] != 10 || !hist[0].Start.Equal(base) {
+		t.Errorf("unexpected first bin %+v", hist[0])
+	}
+
+	// Reopen to check persistence, then simulate a modem reboot
+	e, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Add(sample(base.Add(20*time.Minute), 50, 2)); err != nil {
+		t.Fatal(err)
+	}
+	cur := e.Current(Interval15Min)
+	if cur.Counts["ne_es"] != 2 {
+		t.Errorf("expected 2 errored seconds in current bin, got %d", cur.Counts["ne_es"])
+	}
+	if !cur.Suspect {
+		t.Error("bin with a modem reboot should be suspect")
+	}
+	if day := e.Current(Interval1Day); day.Counts["ne_es"] != 12 {
+		t.Errorf("expected 12 errored seconds in day bin, got %d", day.Counts["ne_es"])
+	}
+}
+
+func TestEngineContinuous(t *testing.T) {
+	e, err := Open(filepath.Join(t.TempDir(), "pm.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.MaxGap = 2 * time.Minute
+	base := time.Date(2022, 11, 1, 10, 0, 30, 0, time.UTC)
+	for i := 0; i <= 31; i++ {
+		if err := e.Add(sample(base.Add(time.Duration(i)*time.Minute), 1000+uint32(i)*60, uint32(i)*2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hist := e.Query(Interval15Min, time.Time{}, time.Time{})
+	if len(hist) != 2 {
+		t.Fatalf("expected 2 completed bins, got %d", len(hist))
+	}
+	// The first bin has no sample before it
+	if !hist[1].Suspect {
+		t.Error("first bin should be suspect")
+	}
+	if hist[0].Suspect || e.Current(Interval15Min).Suspect {
+		t.Error("continuously sampled bins should not be suspect")
+	}
+	// Increments between samples across a bin end are split between bins
+	if hist[1].Counts["ne_es"] != 29 || hist[0].Counts["ne_es"] != 30 {
+		t.Errorf("expected 29 and 30 errored seconds, got %d and %d", hist[1].Counts["ne_es"], hist[0].Counts["ne_es"])
+	}
+
+	// A missing sample makes the bin suspect
+	if err := e.Add(sample(base.Add(40*time.Minute), 1000+40*60, 80)); err != nil {
+		t.Fatal(err)
+	}
+	if !e.Current(Interval15Min).Suspect {
+		t.Error("bin with missing samples should be suspect")
+	}
+}
+
+func TestEngineClockBackwards(t *testing.T) {
+	e, err := Open(filepath.Join(t.TempDir(), "pm.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2022, 11, 1, 10, 0, 30, 0, time.UTC)
+	for i := 0; i <= 16; i++ {
+		if err := e.Add(sample(base.Add(time.Duration(i)*time.Minute), 1000+uint32(i)*60, uint32(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e.Current(Interval15Min).Suspect {
+		t.Fatal("continuously sampled bin should not be suspect")
+	}
+	if err := e.Add(sample(base.Add(15*time.Minute), 1000+17*60, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if !e.Current(Interval15Min).Suspect || !e.Current(Interval1Day).Suspect {
+		t.Error("bins with a sample going back in time should be suspect")
+	}
+	if c := e.Current(Interval15Min).Counts["ne_es"]; c != 2 {
+		t.Errorf("expected the increments of the sample to be dropped, got %d errored seconds", c)
+	}
+}
@@ -0,0 +1,176 @@
+// Package watchdog detects a stuck modem by monitoring OidTicks and recovers
+// it by running a ladder of increasingly disruptive recovery steps.
+package watchdog
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// EventKind is the kind of problem detected by the watchdog.
+type EventKind int
+
+const (
+	// EventStall is raised if the ticks stopped incrementing.
+	EventStall EventKind = iota
+	// EventBackwards is raised if the ticks went backwards, which means that
+	// the modem rebooted on its own.
+	EventBackwards
+	// EventNoResponse is raised if the ticks could not be read.
+	EventNoResponse
+)
+
+var eventKindDesc = map[EventKind]string{
+	EventStall:      "ticks stalled",
+	EventBackwards:  "ticks went backwards",
+	EventNoResponse: "session not responding",
+}
+
+func (k EventKind) String() string {
+	return eventKindDesc[k]
+}
+
+// Event describes a detected problem.
+type Event struct {
+	Time      time.Time
+	Kind      EventKind
+	Ticks     uint32
+	PrevTicks uint32
+	Err       error
+}
+
+func (e Event) String() string {
+	switch e.Kind {
+	case EventNoResponse:
+		return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+	default:
+		return fmt.Sprintf("%v (%d -> %d)", e.Kind, e.PrevTicks, e.Ticks)
+	}
+}
+
+// Step is a single step of the recovery ladder.
+type Step struct {
+	Name string
+	// Run performs the recovery. The reason describes why it is run.
+	Run func(reason string) error
+}
+
+// Watchdog checks the ticks counter at regular intervals and runs the recovery
+// ladder if there is a problem. Every problem which persists after a
+// recovery step escalates to the next step, once the last step is reached it
+// is repeated. A successful check resets the ladder.
+type Watchdog struct {
+	// ReadTicks reads the current value of OidTicks.
+	ReadTicks func() (uint32, error)
+	// Steps is the recovery ladder, generally reconnect, reboot and
+	// firmware re-download.
+	Steps []Step
+	// Interval between checks
+	Interval time.Duration
+	// StallChecks is the number of consecutive checks without the ticks
+	// incrementing before a stall is raised.
+	StallChecks int
+	// OnEvent is called for every detected problem.
+	OnEvent func(e Event)
+	Logger  io.Writer
+
+	haveTicks bool
+	lastTicks uint32
+	stalled   int
+	level     int
+}
+
+// Check performs a single check, running the recovery ladder if necessary.
+// It returns the detected problem or nil.
+func (w *Watchdog) Check() *Event {
+	ev := w.evaluate(w.ReadTicks())
+	if ev == nil {
+		return nil
+	}
+	ev.Time = time.Now()
+	if w.OnEvent != nil {
+		w.OnEvent(*ev)
+	}
+	// A modem which rebooted by itself is working, no need to recover.
+	if ev.Kind != EventBackwards {
+		w.recover(ev.String())
+		w.haveTicks = false
+		w.stalled = 0
+	}
+	return ev
+}
+
+func (w *Watchdog) evaluate(ticks uint32, err error) *Event {
+	if err != nil {
+		return &Event{Kind: EventNoResponse, Err: err}
+	}
+	prev, hadTicks := w.lastTicks, w.haveTicks
+	w.lastTicks, w.haveTicks = ticks, true
+	if !hadTicks {
+		return nil
+	}
+	switch {
+	case ticks == prev:
+		w.stalled++
+		if w.stalled >= w.StallChecks {
+			return &Event{Kind: EventStall, Ticks: ticks, PrevTicks: prev}
+		}
+		return nil
+	// Ticks wrap around after 2^32 increments, only consider large jumps
+	// from the top to the bottom of the range to be a wrap.
+	case ticks < prev && !(prev >= 0xc0000000 && ticks < 0x40000000):
+		w.stalled = 0
+		return &Event{Kind: EventBackwards, Ticks: ticks, PrevTicks: prev}
+	}
+	w.stalled = 0
+	w.level = 0
+	return nil
+}
+
+// recover runs the current step of the recovery ladder and escalates
+// further if it fails.
+func (w *Watchdog) recover(reason string) {
+	if len(w.Steps) == 0 {
+		w.logf("watchdog: %v, no recovery steps configured", reason)
+		return
+	}
+	for {
+		step := w.Steps[w.level]
+		last := w.level == len(w.Steps)-1
+		if !last {
+			w.level++
+		}
+		w.logf("watchdog: running recovery step %q: %v", step.Name, reason)
+		err := step.Run(reason)
+		if err == nil {
+			w.logf("watchdog: recovery step %q succeeded", step.Name)
+			return
+		}
+		w.logf("watchdog: recovery step %q failed: %v", step.Name, err)
+		if last {
+			return
+		}
+		reason = fmt.Sprintf("recovery step %q failed: %v", step.Name, err)
+	}
+}
+
+func (w *Watchdog) logf(format string, args ...any) {
+	if w.Logger != nil {
+		fmt.Fprintf(w.Logger, format+"\n", args...)
+	}
+}
+
+// Run checks at the configured interval until stop is closed.
+func (w *Watchdog) Run(stop <-chan struct{}) {
+	t := time.NewTicker(w.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			w.Check()
+		}
+	}
+}
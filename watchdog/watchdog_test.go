@@ -0,0 +1,63 @@
+package watchdog
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWatchdog(t *testing.T) {
+	var ticks uint32
+	var readErr error
+	var ran []string
+	step := func(name string, err error) Step {
+		return Step{Name: name, Run: func(reason string) error {
+			ran = append(ran, name)
+			return err
+		}}
+	}
+	w := Watchdog{
+		ReadTicks:   func() (uint32, error) { return ticks, readErr },
+		Steps:       []Step{step("reconnect", nil), step("reboot", errors.New("failed")), step("redownload", nil)},
+		StallChecks: 2,
+	}
+	check := func(v uint32, expected *EventKind) {
+		t.Helper()
+		ticks = v
+		ev := w.Check()
+		if expected == nil && ev != nil {
+			t.Errorf("ticks %d: unexpected event %v", v, ev)
+		}
+		if expected != nil && (ev == nil || ev.Kind != *expected) {
+			t.Errorf("ticks %d: expected %v, got %v", v, *expected, ev)
+		}
+	}
+	stall, backwards, noResponse := EventStall, EventBackwards, EventNoResponse
+
+	check(100, nil)
+	check(200, nil)
+	check(200, nil)
+	check(200, &stall)
+	if len(ran) != 1 || ran[0] != "reconnect" {
+		t.Fatalf("expected reconnect, ran %v", ran)
+	}
+	// Still broken, escalates to reboot which fails and thus redownload
+	readErr = errors.New("timeout")
+	check(200, &noResponse)
+	if len(ran) != 3 || ran[1] != "reboot" || ran[2] != "redownload" {
+		t.Fatalf("expected reboot and redownload, ran %v", ran)
+	}
+	readErr = nil
+	check(300, nil)
+	check(400, nil)
+	check(10, &backwards)
+	if len(ran) != 3 {
+		t.Errorf("backwards ticks should not run recovery, ran %v", ran)
+	}
+	check(0xfffffff0, nil)
+	check(0x10, nil)
+	check(0x10, nil)
+	check(0x10, &stall)
+	if ran[3] != "reconnect" {
+		t.Errorf("healthy checks should reset the ladder, ran %v", ran)
+	}
+}